@@ -0,0 +1,180 @@
+// emit-sim is an offline simulator of the emitter's decisions.
+// It reads a scenario of the emitter config, validators set and a synthetic timeline,
+// and prints the emit/skip decision on each step of the timeline.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Fantom-foundation/lachesis-base/emitter/ancestor"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/Fantom-foundation/lachesis-base/inter/pos"
+	"github.com/Fantom-foundation/lachesis-base/utils/piecefunc"
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/Fantom-foundation/go-opera/evmcore"
+	"github.com/Fantom-foundation/go-opera/gossip/emitter"
+	"github.com/Fantom-foundation/go-opera/inter"
+	"github.com/Fantom-foundation/go-opera/opera"
+	"github.com/Fantom-foundation/go-opera/utils"
+)
+
+// step is a single step of the synthetic timeline
+type step struct {
+	// At is the time since the simulation start
+	At  utils.JSONDuration `json:"at"`
+	Txs bool               `json:"txs"`
+	// Metric is the event metric in range [0, 1], 1 if omitted
+	Metric *float64 `json:"metric"`
+	// GasPowerLeft is the gas power of the event, LimitedTpsThreshold if omitted
+	GasPowerLeft *uint64   `json:"gasPowerLeft"`
+	Block        idx.Block `json:"block"`
+}
+
+// scenario is the simulation input
+type scenario struct {
+	// Config overrides the default emitter config
+	Config json.RawMessage `json:"config"`
+	// Rules are the network rules, "main" or "fake", "main" if omitted
+	Rules      string                         `json:"rules"`
+	Validator  idx.ValidatorID                `json:"validator"`
+	Validators map[idx.ValidatorID]pos.Weight `json:"validators"`
+	Steps      []step                         `json:"steps"`
+}
+
+// rules returns the network rules of the scenario
+func (s *scenario) rules() (opera.Rules, error) {
+	switch s.Rules {
+	case "", "main":
+		return opera.MainNetRules(), nil
+	case "fake":
+		return opera.FakeNetRules(), nil
+	default:
+		return opera.Rules{}, fmt.Errorf("unknown rules %q, expected \"main\" or \"fake\"", s.Rules)
+	}
+}
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "emit-sim"
+	app.Usage = "simulate emitter decisions on a synthetic timeline"
+	app.ArgsUsage = "<scenario.json>"
+	app.Action = func(ctx *cli.Context) error {
+		if ctx.NArg() != 1 {
+			return errors.New("scenario file is expected")
+		}
+		return simulate(ctx.Args().First(), os.Stdout)
+	}
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func readScenario(path string) (*scenario, emitter.Config, error) {
+	cfg := emitter.DefaultConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, cfg, err
+	}
+	s := &scenario{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, cfg, fmt.Errorf("failed to decode scenario: %v", err)
+	}
+	if len(s.Config) != 0 {
+		if err := json.Unmarshal(s.Config, &cfg); err != nil {
+			return nil, cfg, fmt.Errorf("failed to decode emitter config: %v", err)
+		}
+	}
+	cfg.Validator.ID = s.Validator
+	if _, ok := s.Validators[s.Validator]; !ok {
+		return nil, cfg, fmt.Errorf("validator %d isn't in the validators set", s.Validator)
+	}
+	return s, cfg, nil
+}
+
+func simulate(path string, out io.Writer) error {
+	s, cfg, err := readScenario(path)
+	if err != nil {
+		return err
+	}
+	validators := pos.NewBuilder()
+	for id, weight := range s.Validators {
+		validators.Set(id, weight)
+	}
+
+	rules, err := s.rules()
+	if err != nil {
+		return err
+	}
+	start := evmcore.DefaultFakeGenesisTime().Time()
+	sim, err := emitter.NewSimulation(cfg, validators.Build(), rules, start)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "STEP\tAT\tTXS\tMETRIC\tPOWER\tDECISION\tREASON\tPASSED")
+	var (
+		emitted    int
+		reasons    = make(map[string]int)
+		selfParent *inter.Event
+	)
+	for i, st := range s.Steps {
+		metric := 1.0
+		if st.Metric != nil {
+			metric = *st.Metric
+		}
+		power := cfg.LimitedTpsThreshold
+		if st.GasPowerLeft != nil {
+			power = *st.GasPowerLeft
+		}
+		me := &inter.MutableEventPayload{}
+		me.SetCreator(s.Validator)
+		me.SetCreationTime(inter.Timestamp(start.Add(time.Duration(st.At)).UnixNano()))
+		me.SetGasPowerLeft(inter.GasPowerLeft{Gas: [inter.GasPowerConfigs]uint64{power, power}})
+
+		sim.SetLatestBlock(st.Block)
+		d := sim.ReplayDecisions([]emitter.RecordedObservation{{
+			Event:      me,
+			SelfParent: selfParent,
+			Txs:        st.Txs,
+			Metric:     ancestor.Metric(metric * piecefunc.DecimalUnit),
+		}})[0]
+
+		decision := "skip"
+		if d.Allowed {
+			decision = "emit"
+			emitted++
+			selfParent = &me.Build().Event
+		}
+		reasons[d.Reason]++
+		fmt.Fprintf(w, "%d\t%s\t%t\t%.3f\t%d\t%s\t%s\t%s\n", i, time.Duration(st.At), st.Txs, metric, power, decision, d.Reason, d.PassedTime)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "\ndecisions: %d, emitted: %d, skipped: %d\n", len(s.Steps), emitted, len(s.Steps)-emitted)
+	if len(s.Steps) != 0 {
+		if span := time.Duration(s.Steps[len(s.Steps)-1].At); span > 0 {
+			fmt.Fprintf(out, "event rate: %.3f events/s\n", float64(emitted)/span.Seconds())
+		}
+	}
+	names := make([]string, 0, len(reasons))
+	for reason := range reasons {
+		names = append(names, reason)
+	}
+	sort.Strings(names)
+	for _, reason := range names {
+		fmt.Fprintf(out, "%s: %d\n", reason, reasons[reason])
+	}
+	return nil
+}
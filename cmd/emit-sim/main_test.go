@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulate(t *testing.T) {
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "scenario.json")
+	require.NoError(os.WriteFile(path, []byte(`{
+		"validator": 1,
+		"validators": {"1": 1, "2": 1, "3": 1},
+		"steps": [
+			{"at": "100ms", "txs": true, "block": 1},
+			{"at": "200ms", "txs": true, "block": 1},
+			{"at": "300ms", "txs": false, "block": 2},
+			{"at": "1s", "txs": true, "metric": 0.5, "block": 3}
+		]
+	}`), 0600))
+
+	out := &bytes.Buffer{}
+	require.NoError(simulate(path, out))
+	lines := strings.Split(out.String(), "\n")
+	require.Contains(lines, "decisions: 4, emitted: 2, skipped: 2")
+	require.Contains(lines, "min_interval: 1")
+	require.Contains(lines, "idle_no_txs: 1")
+	require.Contains(lines, "allowed: 2")
+	emits := 0
+	for _, line := range lines {
+		if strings.Contains(line, " emit ") {
+			emits++
+		}
+	}
+	require.Equal(2, emits)
+
+	require.NoError(os.WriteFile(path, []byte(`{"validator": 4, "validators": {"1": 1}}`), 0600))
+	require.Error(simulate(path, out))
+
+	// rules are chosen by the scenario
+	require.NoError(os.WriteFile(path, []byte(`{"rules": "fake", "validator": 1, "validators": {"1": 1}}`), 0600))
+	require.NoError(simulate(path, out))
+	require.NoError(os.WriteFile(path, []byte(`{"rules": "test", "validator": 1, "validators": {"1": 1}}`), 0600))
+	require.Error(simulate(path, out))
+
+	// invalid config is rejected by the simulation
+	require.NoError(os.WriteFile(path, []byte(`{"validator": 1, "validators": {"1": 1}, "config": {"EmergencyThreshold": 2, "NoTxsThreshold": 1}}`), 0600))
+	require.Error(simulate(path, out))
+}
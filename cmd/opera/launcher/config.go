@@ -590,6 +590,9 @@ func mayMakeAllConfigs(ctx *cli.Context) (*config, error) {
 	if err := cfg.Opera.Validate(); err != nil {
 		return nil, err
 	}
+	if err := cfg.Emitter.Validate(); err != nil {
+		return nil, err
+	}
 
 	return &cfg, nil
 }
@@ -13,7 +13,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/params"
 
-	"github.com/Fantom-foundation/go-opera/integration/makefakegenesis"
+	"github.com/Fantom-foundation/go-opera/evmcore"
 )
 
 const (
@@ -143,5 +143,5 @@ func trulyRandInt(lo, hi int) int {
 }
 
 func genesisStart() string {
-	return time.Unix(int64(makefakegenesis.FakeGenesisTime.Unix()), 0).Format("Mon Jan 02 2006 15:04:05 GMT-0700 (MST)")
+	return time.Unix(int64(evmcore.DefaultFakeGenesisTime().Unix()), 0).Format("Mon Jan 02 2006 15:04:05 GMT-0700 (MST)")
 }
@@ -19,38 +19,230 @@ package evmcore
 import (
 	"crypto/ecdsa"
 	"errors"
+	"fmt"
 	"math"
 	"math/big"
+	"sync"
 	"time"
 
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/Fantom-foundation/lachesis-base/inter/pos"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
 
 	"github.com/Fantom-foundation/go-opera/inter"
 )
 
-var FakeGenesisTime = inter.Timestamp(1608600000 * time.Second)
+const defaultFakeGenesisTime = inter.Timestamp(1608600000 * time.Second)
+
+// DefaultFakeGenesisTime returns the default time of the fake genesis block.
+func DefaultFakeGenesisTime() inter.Timestamp {
+	return defaultFakeGenesisTime
+}
+
+// FakeGenesisConfig is a configuration of the fake genesis.
+type FakeGenesisConfig struct {
+	Time     inter.Timestamp
+	Balances map[common.Address]*big.Int
+
+	// Number and ParentHash of the genesis block, for chains which don't start from zero height
+	Number     uint64
+	ParentHash common.Hash
+
+	// Registry is a list of well-known addresses, such as system contracts, which exist from genesis.
+	// They are seeded as code-less accounts with nonce 1 and zero balance, so they aren't considered empty.
+	Registry []common.Address
+
+	// SkipClean commits the state with flush(statedb, false) instead of a clean commit.
+	// Empty accounts aren't deleted then, and the trie database is capped right after the commit,
+	// which releases the memory of cached trie nodes at the cost of re-reading them from db on next writes.
+	// A long-lived harness may prefer the clean commit to keep the nodes cached between blocks.
+	// The root is the same in both modes unless the allocation has empty accounts.
+	SkipClean bool
+
+	// Tokens are the token contracts which get balances of the holders written into their storage.
+	Tokens []FakeTokenBalances
+
+	// ChainConfig is the chain config of the EVM execution on top of the genesis, see NewFakeGenesisEVM.
+	// The genesis block gets the initial base fee if London is active at the genesis number.
+	// Nil keeps the genesis block without base fee.
+	ChainConfig *params.ChainConfig
+}
+
+// FakeTokenBalances is a set of genesis balances of a token contract.
+type FakeTokenBalances struct {
+	Token common.Address
+	// SlotCalc returns the storage slot of the holder's balance, it must match the token's storage layout.
+	SlotCalc func(holder common.Address) common.Hash
+	Holders  map[common.Address]*big.Int
+	// SupplySlot is the storage slot of the token's total supply, it's optional.
+	// If set, the total supply is adjusted by the difference between the written and the replaced balances.
+	SupplySlot *common.Hash
+}
+
+// MappingSlot returns a storage slot calculator of a Solidity mapping(address => uint256)
+// declared at the given slot index, e.g. 0 for the balances of OpenZeppelin ERC20.
+func MappingSlot(index uint64) func(holder common.Address) common.Hash {
+	return func(holder common.Address) common.Hash {
+		return crypto.Keccak256Hash(
+			common.LeftPadBytes(holder.Bytes(), 32),
+			common.LeftPadBytes(new(big.Int).SetUint64(index).Bytes(), 32),
+		)
+	}
+}
 
 // ApplyFakeGenesis writes or updates the genesis block in db.
 func ApplyFakeGenesis(statedb *state.StateDB, time inter.Timestamp, balances map[common.Address]*big.Int) (*EvmBlock, error) {
-	for acc, balance := range balances {
-		statedb.SetBalance(acc, balance)
+	return ApplyFakeGenesisConfig(statedb, FakeGenesisConfig{
+		Time:     time,
+		Balances: balances,
+	})
+}
+
+// ApplyFakeGenesisState is the same as ApplyFakeGenesis, but it also returns the genesis state for further mutation.
+// The returned state is opened at the genesis root on top of the same state database as statedb,
+// which must not be used after the call. The returned state is owned by the caller,
+// and its changes are persisted only when the caller commits them.
+func ApplyFakeGenesisState(statedb *state.StateDB, time inter.Timestamp, balances map[common.Address]*big.Int) (*EvmBlock, *state.StateDB, error) {
+	block, err := ApplyFakeGenesis(statedb, time, balances)
+	if err != nil {
+		return nil, nil, err
+	}
+	genesisState, err := state.New(block.Root, statedb.Database(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, genesisState, nil
+}
+
+// TryApplyFakeGenesis is the same as ApplyFakeGenesis. It's the counterpart of MustApplyFakeGenesis
+// for callers which must recover from an error rather than terminate the process.
+func TryApplyFakeGenesis(statedb *state.StateDB, time inter.Timestamp, balances map[common.Address]*big.Int) (*EvmBlock, error) {
+	return ApplyFakeGenesis(statedb, time, balances)
+}
+
+// ApplyFakeGenesisConfig writes or updates the genesis block in db according to the config.
+func ApplyFakeGenesisConfig(statedb *state.StateDB, cfg FakeGenesisConfig) (*EvmBlock, error) {
+	if err := checkFakeBalances(cfg.Balances); err != nil {
+		return nil, err
+	}
+	setFakeBalances(statedb, cfg.Balances)
+	for _, addr := range cfg.Registry {
+		if statedb.GetNonce(addr) == 0 {
+			statedb.SetNonce(addr, 1)
+		}
+	}
+	for _, token := range cfg.Tokens {
+		if err := setFakeTokenBalances(statedb, token); err != nil {
+			return nil, err
+		}
 	}
 
 	// initial block
-	root, err := flush(statedb, true)
+	root, err := flush(statedb, !cfg.SkipClean)
 	if err != nil {
 		return nil, err
 	}
-	block := genesisBlock(time, root)
+	block := genesisBlock(cfg.Time, root)
+	block.Number = new(big.Int).SetUint64(cfg.Number)
+	block.ParentHash = cfg.ParentHash
+	if cfg.ChainConfig != nil && cfg.ChainConfig.IsLondon(block.Number) {
+		block.BaseFee = big.NewInt(params.InitialBaseFee)
+	}
 
 	return block, nil
 }
 
+// NewFakeGenesisEVM creates an EVM for the execution on top of the genesis state according to the chain config.
+// The chain config should be the same as the one the genesis is applied with, so the base fee is consistent.
+func NewFakeGenesisEVM(genesis *EvmBlock, statedb vm.StateDB, chainConfig *params.ChainConfig) *vm.EVM {
+	return NewFakeGenesisTracedEVM(genesis, statedb, chainConfig, nil)
+}
+
+// NewFakeGenesisTracedEVM is the same as NewFakeGenesisEVM, but the execution is traced by the tracer.
+// Nil tracer disables the tracing. See BlockGen.SetTracer for the chains generated from the genesis.
+func NewFakeGenesisTracedEVM(genesis *EvmBlock, statedb vm.StateDB, chainConfig *params.ChainConfig, tracer vm.Tracer) *vm.EVM {
+	vmConfig := vm.Config{
+		Debug:  tracer != nil,
+		Tracer: tracer,
+	}
+	return vm.NewEVM(NewEVMBlockContext(&genesis.EvmHeader, nil, nil), vm.TxContext{}, statedb, chainConfig, vmConfig)
+}
+
+// checkFakeBalances rejects nil and negative balances before the state is mutated
+func checkFakeBalances(balances map[common.Address]*big.Int) error {
+	for acc, balance := range balances {
+		if balance == nil {
+			return fmt.Errorf("nil balance of %s", acc.Hex())
+		}
+		if balance.Sign() < 0 {
+			return fmt.Errorf("negative balance %s of %s", balance, acc.Hex())
+		}
+	}
+	return nil
+}
+
+// setFakeBalances writes the balances serially. state.StateDB isn't safe for concurrent use,
+// and the expensive hashing of the accounts happens in the commit anyway,
+// so concurrent writers would only contend for a lock around every write.
+func setFakeBalances(statedb *state.StateDB, balances map[common.Address]*big.Int) {
+	for acc, balance := range balances {
+		statedb.SetBalance(acc, balance)
+	}
+}
+
+func setFakeTokenBalances(statedb *state.StateDB, token FakeTokenBalances) error {
+	if token.SlotCalc == nil {
+		return fmt.Errorf("no slot calculator for token %s", token.Token.Hex())
+	}
+	supply := new(big.Int)
+	if token.SupplySlot != nil {
+		supply = statedb.GetState(token.Token, *token.SupplySlot).Big()
+	}
+	for holder, balance := range token.Holders {
+		if balance == nil || balance.Sign() < 0 || balance.BitLen() > 256 {
+			return fmt.Errorf("invalid balance of %s for token %s", holder.Hex(), token.Token.Hex())
+		}
+		slot := token.SlotCalc(holder)
+		supply.Sub(supply, statedb.GetState(token.Token, slot).Big())
+		supply.Add(supply, balance)
+		statedb.SetState(token.Token, slot, common.BigToHash(balance))
+	}
+	if token.SupplySlot != nil {
+		if supply.BitLen() > 256 {
+			return fmt.Errorf("total supply overflow for token %s", token.Token.Hex())
+		}
+		statedb.SetState(token.Token, *token.SupplySlot, common.BigToHash(supply))
+	}
+	return nil
+}
+
+// ApplyBalanceDeltas adds the deltas to the balances of a committed state and commits it again.
+// Negative deltas are subtracted. No balance is changed if any of them would become negative.
+func ApplyBalanceDeltas(statedb *state.StateDB, deltas map[common.Address]*big.Int) (common.Hash, error) {
+	for acc, delta := range deltas {
+		if delta == nil {
+			return common.Hash{}, fmt.Errorf("nil balance delta of %s", acc.Hex())
+		}
+		if balance := statedb.GetBalance(acc); new(big.Int).Add(balance, delta).Sign() < 0 {
+			return common.Hash{}, fmt.Errorf("balance underflow of %s: balance %s, delta %s", acc.Hex(), balance, delta)
+		}
+	}
+	for acc, delta := range deltas {
+		statedb.SetBalance(acc, new(big.Int).Add(statedb.GetBalance(acc), delta))
+	}
+	return flush(statedb, true)
+}
+
 func flush(statedb *state.StateDB, clean bool) (root common.Hash, err error) {
 	root, err = statedb.Commit(clean)
 	if err != nil {
@@ -83,7 +275,124 @@ func genesisBlock(time inter.Timestamp, root common.Hash) *EvmBlock {
 	return block
 }
 
-// MustApplyFakeGenesis writes the genesis block and state to db, panicking on error.
+// NewFakeGenesisStateDB creates an empty state on top of db for ApplyFakeGenesis.
+// cacheMB is the memory allowance of the clean trie nodes cache, which speeds up big allocations
+// at the cost of memory. Zero disables the cache, same as state.NewDatabase does.
+// Only the clean cache is configurable: the trie database has no dirty cache limit to tune,
+// and the dirty nodes are written to db at once when the genesis is flushed.
+// The cache sizing doesn't affect the genesis root.
+func NewFakeGenesisStateDB(db ethdb.Database, cacheMB int) (*state.StateDB, error) {
+	return state.New(common.Hash{}, state.NewDatabaseWithConfig(db, &trie.Config{Cache: cacheMB}), nil)
+}
+
+// VerifyFakeGenesisRoot applies the balances to an empty in-memory state and checks the resulting root.
+func VerifyFakeGenesisRoot(balances map[common.Address]*big.Int, expected common.Hash) error {
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		return err
+	}
+	block, err := ApplyFakeGenesis(statedb, DefaultFakeGenesisTime(), balances)
+	if err != nil {
+		return err
+	}
+	if block.Root != expected {
+		return fmt.Errorf("fake genesis root mismatch for %d accounts: have %s, want %s", len(balances), block.Root.Hex(), expected.Hex())
+	}
+	return nil
+}
+
+// DiffFakeGenesis computes the fake genesis roots of two allocations and the balance deltas from a to b.
+// An account missing in one of the allocations is counted with zero balance there,
+// and accounts with equal balances are omitted from the deltas.
+// It panics if any of the balances is invalid.
+func DiffFakeGenesis(a, b map[common.Address]*big.Int) (rootA, rootB common.Hash, deltas map[common.Address]*big.Int) {
+	root := func(balances map[common.Address]*big.Int) common.Hash {
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		if err != nil {
+			panic(err)
+		}
+		block, err := ApplyFakeGenesis(statedb, DefaultFakeGenesisTime(), balances)
+		if err != nil {
+			panic(err)
+		}
+		return block.Root
+	}
+
+	deltas = make(map[common.Address]*big.Int)
+	for acc, balance := range b {
+		deltas[acc] = new(big.Int).Set(balance)
+	}
+	for acc, balance := range a {
+		delta, ok := deltas[acc]
+		if !ok {
+			delta = new(big.Int)
+			deltas[acc] = delta
+		}
+		delta.Sub(delta, balance)
+	}
+	for acc, delta := range deltas {
+		if delta.Sign() == 0 {
+			delete(deltas, acc)
+		}
+	}
+	return root(a), root(b), deltas
+}
+
+// SnapshotFakeGenesis builds the fake genesis state once into an in-memory db.
+// The state may be cheaply reopened with RestoreFakeGenesis as many times as needed.
+func SnapshotFakeGenesis(balances map[common.Address]*big.Int) (root common.Hash, db ethdb.Database, err error) {
+	db = rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	if err != nil {
+		return common.Hash{}, nil, err
+	}
+	block, err := ApplyFakeGenesis(statedb, DefaultFakeGenesisTime(), balances)
+	if err != nil {
+		return common.Hash{}, nil, err
+	}
+	return block.Root, db, nil
+}
+
+// RestoreFakeGenesis reopens the fake genesis state built by SnapshotFakeGenesis.
+// Uncommitted changes of the returned state don't affect the snapshot.
+func RestoreFakeGenesis(db ethdb.Database, root common.Hash) *state.StateDB {
+	statedb, err := state.New(root, state.NewDatabase(db), nil)
+	if err != nil {
+		log.Crit("RestoreFakeGenesis", "err", err)
+	}
+	return statedb
+}
+
+// FakeGenesisBalances returns genesis balances which fund first n fake accounts with each wei.
+// n must not exceed MaxFakeKeys.
+func FakeGenesisBalances(n uint32, each *big.Int) map[common.Address]*big.Int {
+	if n > MaxFakeKeys {
+		panic(fmt.Errorf("fake accounts num %d is out of range, max %d", n, MaxFakeKeys))
+	}
+	if each == nil || each.Sign() < 0 {
+		panic(errors.New("fake genesis balance must be non-negative"))
+	}
+	balances := make(map[common.Address]*big.Int, n)
+	for i := uint32(1); i <= n; i++ {
+		balances[FakeAddress(i)] = new(big.Int).Set(each)
+	}
+	return balances
+}
+
+// FakeGenesisTotalSupply returns the sum of the genesis balances.
+// Nil balances are counted as zero, though ApplyFakeGenesis rejects them.
+func FakeGenesisTotalSupply(balances map[common.Address]*big.Int) *big.Int {
+	total := new(big.Int)
+	for _, balance := range balances {
+		if balance != nil {
+			total.Add(total, balance)
+		}
+	}
+	return total
+}
+
+// MustApplyFakeGenesis writes the genesis block and state to db, terminating the process on error.
+// Use TryApplyFakeGenesis if the error must be handled.
 func MustApplyFakeGenesis(statedb *state.StateDB, time inter.Timestamp, balances map[common.Address]*big.Int) *EvmBlock {
 	block, err := ApplyFakeGenesis(statedb, time, balances)
 	if err != nil {
@@ -92,8 +401,32 @@ func MustApplyFakeGenesis(statedb *state.StateDB, time inter.Timestamp, balances
 	return block
 }
 
+// FakeValidators returns a set of n validators with IDs 1..n, which are aligned with FakeKey, each with the weight.
+// Zero weight makes an empty set.
+func FakeValidators(n uint32, weight pos.Weight) *pos.Validators {
+	builder := pos.NewBuilder()
+	for i := uint32(1); i <= n; i++ {
+		builder.Set(idx.ValidatorID(i), weight)
+	}
+	return builder.Build()
+}
+
 // FakeKey gets n-th fake private key.
 func FakeKey(n uint32) *ecdsa.PrivateKey {
+	key, _ := crypto.ToECDSA(FakeKeyBytes(n))
+	return key
+}
+
+// FakeKeyBytes gets n-th fake private key as raw 32 bytes.
+func FakeKeyBytes(n uint32) []byte {
+	return hexutil.MustDecode(FakeKeyHex(n))
+}
+
+// MaxFakeKeys is the number of the available fake keys.
+const MaxFakeKeys = 100
+
+// FakeKeyHex gets n-th fake private key as 0x-prefixed hex string.
+func FakeKeyHex(n uint32) string {
 	var keys = [400]string{
 		"0x163f5f0f9a621d72fedd85ffca3d08d131ab4e812181e0d30ffd1c885d20aac7",
 		"0x3144c0aa4ced56dc15c79b045bc5559a5ac9363d98db6df321fe3847a103740f",
@@ -497,10 +830,36 @@ func FakeKey(n uint32) *ecdsa.PrivateKey {
 		"0x2f34c9b455462bb3a4ffb65ea87244e714c1f86ec497ea4f19927f71640a60e7",
 	}
 
-	if n == 0 || n > 100 {
+	if n == 0 || n > MaxFakeKeys {
 		panic(errors.New("validator num is out of range"))
 	}
 
-	key, _ := crypto.ToECDSA(hexutil.MustDecode(keys[n-1]))
-	return key
+	return keys[n-1]
+}
+
+// fakeAddresses is a lazily filled cache of FakeAddress results, indexed by n-1
+var fakeAddresses struct {
+	sync.RWMutex
+	list []common.Address
+}
+
+// FakeAddress gets the address of n-th fake private key.
+func FakeAddress(n uint32) common.Address {
+	fakeAddresses.RLock()
+	if n != 0 && int(n) <= len(fakeAddresses.list) && fakeAddresses.list[n-1] != (common.Address{}) {
+		addr := fakeAddresses.list[n-1]
+		fakeAddresses.RUnlock()
+		return addr
+	}
+	fakeAddresses.RUnlock()
+
+	addr := crypto.PubkeyToAddress(FakeKey(n).PublicKey)
+
+	fakeAddresses.Lock()
+	defer fakeAddresses.Unlock()
+	for int(n) > len(fakeAddresses.list) {
+		fakeAddresses.list = append(fakeAddresses.list, common.Address{})
+	}
+	fakeAddresses.list[n-1] = addr
+	return addr
 }
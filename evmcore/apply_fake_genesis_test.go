@@ -0,0 +1,827 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package evmcore
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/Fantom-foundation/lachesis-base/inter/pos"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+
+	"github.com/Fantom-foundation/go-opera/inter"
+)
+
+func TestFakeAddress(t *testing.T) {
+	for _, n := range []uint32{1, 2, 3, 50, 100, 1} {
+		exp := crypto.PubkeyToAddress(FakeKey(n).PublicKey)
+		if got := FakeAddress(n); got != exp {
+			t.Fatalf("address mismatch for key %d: have %s, want %s", n, got.Hex(), exp.Hex())
+		}
+	}
+
+	for _, n := range []uint32{0, 101} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected panic for key %d", n)
+				}
+			}()
+			FakeAddress(n)
+		}()
+	}
+}
+
+func TestFakeGenesisBalances(t *testing.T) {
+	each := big.NewInt(1e18)
+	balances := FakeGenesisBalances(5, each)
+	if len(balances) != 5 {
+		t.Fatalf("wrong number of accounts: have %d, want %d", len(balances), 5)
+	}
+	for i := uint32(1); i <= 5; i++ {
+		balance, ok := balances[FakeAddress(i)]
+		if !ok {
+			t.Fatalf("account %d isn't funded", i)
+		}
+		if balance.Cmp(each) != 0 {
+			t.Fatalf("wrong balance of account %d: have %s, want %s", i, balance, each)
+		}
+	}
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if _, err := ApplyFakeGenesis(statedb, DefaultFakeGenesisTime(), balances); err != nil {
+		t.Fatalf("failed to apply genesis: %v", err)
+	}
+	for addr := range balances {
+		if statedb.GetBalance(addr).Cmp(each) != 0 {
+			t.Fatalf("wrong genesis balance of %s", addr.Hex())
+		}
+	}
+
+	for _, each := range []*big.Int{nil, big.NewInt(-1)} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected panic for balance %v", each)
+				}
+			}()
+			FakeGenesisBalances(1, each)
+		}()
+	}
+
+	if len(FakeGenesisBalances(MaxFakeKeys, each)) != MaxFakeKeys {
+		t.Fatalf("wrong number of accounts for max fake keys")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected panic for %d accounts", MaxFakeKeys+1)
+			}
+		}()
+		FakeGenesisBalances(MaxFakeKeys+1, each)
+	}()
+}
+
+// captureTracer records the top-level call, the executed opcodes and the inner calls
+type captureTracer struct {
+	from, to common.Address
+	value    *big.Int
+	ended    bool
+	ops      []vm.OpCode
+	enters   []vm.OpCode
+}
+
+func (t *captureTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.from, t.to, t.value = from, to, value
+}
+
+func (t *captureTracer) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) {
+	t.ended = true
+}
+
+func (t *captureTracer) CaptureState(_ *vm.EVM, _ uint64, op vm.OpCode, _, _ uint64, _ *vm.ScopeContext, _ []byte, _ int, _ error) {
+	t.ops = append(t.ops, op)
+}
+
+func (t *captureTracer) CaptureEnter(typ vm.OpCode, _ common.Address, _ common.Address, _ []byte, _ uint64, _ *big.Int) {
+	t.enters = append(t.enters, typ)
+}
+
+func (t *captureTracer) CaptureExit([]byte, uint64, error) {}
+
+func (t *captureTracer) CaptureFault(*vm.EVM, uint64, vm.OpCode, uint64, uint64, *vm.ScopeContext, int, error) {
+}
+
+// forwarderCode is a runtime code which forwards the call value to the address
+func forwarderCode(to common.Address) []byte {
+	code := []byte{
+		byte(vm.PUSH1), 0, // retSize
+		byte(vm.PUSH1), 0, // retOffset
+		byte(vm.PUSH1), 0, // argsSize
+		byte(vm.PUSH1), 0, // argsOffset
+		byte(vm.CALLVALUE),
+		byte(vm.PUSH20),
+	}
+	code = append(code, to.Bytes()...)
+	return append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.STOP))
+}
+
+// forwarderOps are the opcodes executed by the forwarderCode
+var forwarderOps = []vm.OpCode{vm.PUSH1, vm.PUSH1, vm.PUSH1, vm.PUSH1, vm.CALLVALUE, vm.PUSH20, vm.GAS, vm.CALL, vm.STOP}
+
+// deployCode returns the contract creation code which deploys the runtime code
+func deployCode(runtime []byte) []byte {
+	const initSize = 14
+	return append([]byte{
+		byte(vm.PUSH2), byte(len(runtime) >> 8), byte(len(runtime)),
+		byte(vm.PUSH1), initSize,
+		byte(vm.PUSH1), 0,
+		byte(vm.CODECOPY),
+		byte(vm.PUSH2), byte(len(runtime) >> 8), byte(len(runtime)),
+		byte(vm.PUSH1), 0,
+		byte(vm.RETURN),
+	}, runtime...)
+}
+
+func checkForwarderTrace(t *testing.T, tracer *captureTracer, contract common.Address) {
+	if !tracer.ended {
+		t.Fatal("tracer didn't observe the call")
+	}
+	if tracer.from != FakeAddress(1) || tracer.to != contract {
+		t.Fatalf("wrong traced call: %s -> %s", tracer.from.Hex(), tracer.to.Hex())
+	}
+	if tracer.value.Cmp(big.NewInt(1000)) != 0 {
+		t.Fatalf("wrong traced value: have %s, want %d", tracer.value, 1000)
+	}
+	if !reflect.DeepEqual(tracer.ops, forwarderOps) {
+		t.Fatalf("wrong traced opcodes: have %v, want %v", tracer.ops, forwarderOps)
+	}
+	if !reflect.DeepEqual(tracer.enters, []vm.OpCode{vm.CALL}) {
+		t.Fatalf("wrong traced inner calls: have %v, want %v", tracer.enters, []vm.OpCode{vm.CALL})
+	}
+}
+
+func TestFakeGenesisTracer(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	genesis := MustApplyFakeGenesis(statedb, DefaultFakeGenesisTime(), FakeGenesisBalances(1, big.NewInt(1e18)))
+
+	tracer := &captureTracer{}
+	to := FakeAddress(2)
+	contract := crypto.CreateAddress(FakeAddress(1), 0)
+	blocks, _, _ := GenerateChain(nil, genesis, db, 1, func(i int, gen *BlockGen) {
+		sign := func(tx *types.Transaction) *types.Transaction {
+			tx, _ = types.SignTx(tx, types.HomesteadSigner{}, FakeKey(1))
+			return tx
+		}
+		// the deployment isn't traced
+		gen.AddTx(sign(types.NewContractCreation(gen.TxNonce(FakeAddress(1)), nil, 200000, nil, deployCode(forwarderCode(to)))))
+		gen.SetTracer(tracer)
+		gen.AddTx(sign(types.NewTransaction(gen.TxNonce(FakeAddress(1)), contract, big.NewInt(1000), 100000, nil, nil)))
+	})
+	checkForwarderTrace(t, tracer, contract)
+	if have := RestoreFakeGenesis(db, blocks[0].Root).GetBalance(to); have.Cmp(big.NewInt(1000)) != 0 {
+		t.Fatalf("wrong forwarded balance: have %s, want %d", have, 1000)
+	}
+}
+
+func TestFakeGenesisTracedEVM(t *testing.T) {
+	contract := common.HexToAddress("0xc0de")
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.SetCode(contract, forwarderCode(FakeAddress(2)))
+	genesis, genesisState, err := ApplyFakeGenesisState(statedb, DefaultFakeGenesisTime(), FakeGenesisBalances(1, big.NewInt(1e18)))
+	if err != nil {
+		t.Fatalf("failed to apply genesis: %v", err)
+	}
+
+	tracer := &captureTracer{}
+	evm := NewFakeGenesisTracedEVM(genesis, genesisState, params.AllEthashProtocolChanges, tracer)
+	if _, _, err := evm.Call(vm.AccountRef(FakeAddress(1)), contract, nil, 100000, big.NewInt(1000)); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	checkForwarderTrace(t, tracer, contract)
+}
+
+func manyFakeBalances(n int) map[common.Address]*big.Int {
+	balances := make(map[common.Address]*big.Int, n)
+	for i := 0; i < n; i++ {
+		addr := common.BytesToAddress(crypto.Keccak256(big.NewInt(int64(i)).Bytes()))
+		balances[addr] = big.NewInt(int64(i + 1))
+	}
+	return balances
+}
+
+func fakeGenesisRoot(t testing.TB, cfg FakeGenesisConfig) common.Hash {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	block, err := ApplyFakeGenesisConfig(statedb, cfg)
+	if err != nil {
+		t.Fatalf("failed to apply genesis: %v", err)
+	}
+	return block.Root
+}
+
+func fakeGenesisRootWithCache(t testing.TB, balances map[common.Address]*big.Int, cacheMB int) common.Hash {
+	statedb, err := NewFakeGenesisStateDB(rawdb.NewMemoryDatabase(), cacheMB)
+	if err != nil {
+		t.Fatalf("cannot create statedb: %v", err)
+	}
+	block, err := ApplyFakeGenesis(statedb, DefaultFakeGenesisTime(), balances)
+	if err != nil {
+		t.Fatalf("failed to apply genesis: %v", err)
+	}
+	return block.Root
+}
+
+func TestFakeGenesisCache(t *testing.T) {
+	balances := manyFakeBalances(2000)
+	expected := fakeGenesisRoot(t, FakeGenesisConfig{Time: DefaultFakeGenesisTime(), Balances: balances})
+	for _, cacheMB := range []int{0, 1, 64} {
+		if root := fakeGenesisRootWithCache(t, balances, cacheMB); root != expected {
+			t.Fatalf("root mismatch with %d MB cache: have %s, want %s", cacheMB, root.Hex(), expected.Hex())
+		}
+	}
+}
+
+func BenchmarkFakeGenesisCache(b *testing.B) {
+	balances := manyFakeBalances(20000)
+	for _, cacheMB := range []int{0, 64} {
+		b.Run(fmt.Sprintf("cache=%dMB", cacheMB), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				fakeGenesisRootWithCache(b, balances, cacheMB)
+			}
+		})
+	}
+}
+
+func TestVerifyFakeGenesisRoot(t *testing.T) {
+	balances := FakeGenesisBalances(3, big.NewInt(1e18))
+	// the pinned root changes only if state trie encoding changes
+	pinned := common.HexToHash("0x50d7da78dcee96c0f0aed701d65e736bce7bc1c15a27293c4b5bb0c99f692bb5")
+	if err := VerifyFakeGenesisRoot(balances, pinned); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyFakeGenesisRoot(balances, common.Hash{}); err == nil {
+		t.Fatal("expected root mismatch")
+	}
+}
+
+// erc20Code is the runtime code of a standard ERC20 token deployed on the Ethereum mainnet
+// at 0x43064693d3d38ad6a7cb579e0d6d9718c8aa6b62, taken from the go-ethereum call tracer tests.
+// Its total supply is stored at slot 0 and the balances mapping is declared at slot 1.
+var erc20Code = common.FromHex("0x6060604052600436106100ba576000357c0100000000000000000000000000000000000000000000000000000000900463ffffffff16806306fdde03146100bf578063095ea7b31461014d57806318160ddd146101a757806323b872dd146101d0578063313ce5671461024957806342966c68146102785780635a3b7e42146102b357806370a082311461034157806379cc67901461038e57806395d89b41146103e8578063a9059cbb14610476578063dd62ed3e146104b8575b600080fd5b34156100ca57600080fd5b6100d2610524565b6040518080602001828103825283818151815260200191508051906020019080838360005b838110156101125780820151818401526020810190506100f7565b50505050905090810190601f16801561013f5780820380516001836020036101000a031916815260200191505b509250505060405180910390f35b341561015857600080fd5b61018d600480803573ffffffffffffffffffffffffffffffffffffffff1690602001909190803590602001909190505061055d565b604051808215151515815260200191505060405180910390f35b34156101b257600080fd5b6101ba6105ea565b6040518082815260200191505060405180910390f35b34156101db57600080fd5b61022f600480803573ffffffffffffffffffffffffffffffffffffffff1690602001909190803573ffffffffffffffffffffffffffffffffffffffff169060200190919080359060200190919050506105f0565b604051808215151515815260200191505060405180910390f35b341561025457600080fd5b61025c610910565b604051808260ff1660ff16815260200191505060405180910390f35b341561028357600080fd5b6102996004808035906020019091905050610915565b604051808215151515815260200191505060405180910390f35b34156102be57600080fd5b6102c6610a18565b6040518080602001828103825283818151815260200191508051906020019080838360005b838110156103065780820151818401526020810190506102eb565b50505050905090810190601f1680156103335780820380516001836020036101000a031916815260200191505b509250505060405180910390f35b341561034c57600080fd5b610378600480803573ffffffffffffffffffffffffffffffffffffffff16906020019091905050610a51565b6040518082815260200191505060405180910390f35b341561039957600080fd5b6103ce600480803573ffffffffffffffffffffffffffffffffffffffff16906020019091908035906020019091905050610a69565b604051808215151515815260200191505060405180910390f35b34156103f357600080fd5b6103fb610bf8565b6040518080602001828103825283818151815260200191508051906020019080838360005b8381101561043b578082015181840152602081019050610420565b50505050905090810190601f1680156104685780820380516001836020036101000a031916815260200191505b509250505060405180910390f35b341561048157600080fd5b6104b6600480803573ffffffffffffffffffffffffffffffffffffffff16906020019091908035906020019091905050610c31565b005b34156104c357600080fd5b61050e600480803573ffffffffffffffffffffffffffffffffffffffff1690602001909190803573ffffffffffffffffffffffffffffffffffffffff16906020019091905050610e34565b6040518082815260200191505060405180910390f35b6040805190810160405280600881526020017f446f70616d696e6500000000000000000000000000000000000000000000000081525081565b600081600260003373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060008573ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff168152602001908152602001600020819055506001905092915050565b60005481565b6000808373ffffffffffffffffffffffffffffffffffffffff161415151561061757600080fd5b81600160008673ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff168152602001908152602001600020541015151561066557600080fd5b600160008473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff1681526020019081526020016000205482600160008673ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff1681526020019081526020016000205401101515156106f157fe5b600260008573ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060003373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002054821115151561077c57600080fd5b81600160008673ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff1681526020019081526020016000206000828254039250508190555081600160008573ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff1681526020019081526020016000206000828254019250508190555081600260008673ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060003373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff168152602001908152602001600020600082825403925050819055508273ffffffffffffffffffffffffffffffffffffffff168473ffffffffffffffffffffffffffffffffffffffff167fddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef846040518082815260200191505060405180910390a3600190509392505050565b601281565b600081600160003373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff168152602001908152602001600020541015151561096557600080fd5b81600160003373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff168152602001908152602001600020600082825403925050819055508160008082825403925050819055503373ffffffffffffffffffffffffffffffffffffffff167fcc16f5dbb4873280815c1ee09dbd06736cffcc184412cf7a71a0fdb75d397ca5836040518082815260200191505060405180910390a260019050919050565b6040805190810160405280600981526020017f446f706d6e20302e32000000000000000000000000000000000000000000000081525081565b60016020528060005260406000206000915090505481565b600081600160008573ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff1681526020019081526020016000205410151515610ab957600080fd5b600260008473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff16815260200190815260200160002060003373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff168152602001908152602001600020548211151515610b4457600080fd5b81600160008573ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff168152602001908152602001600020600082825403925050819055508160008082825403925050819055508273ffffffffffffffffffffffffffffffffffffffff167fcc16f5dbb4873280815c1ee09dbd06736cffcc184412cf7a71a0fdb75d397ca5836040518082815260200191505060405180910390a26001905092915050565b6040805190810160405280600581526020017f444f504d4e00000000000000000000000000000000000000000000000000000081525081565b60008273ffffffffffffffffffffffffffffffffffffffff1614151515610c5757600080fd5b80600160003373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff1681526020019081526020016000205410151515610ca557600080fd5b600160008373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff1681526020019081526020016000205481600160008573ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff168152602001908152602001600020540110151515610d3157fe5b80600160003373ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff1681526020019081526020016000206000828254039250508190555080600160008473ffffffffffffffffffffffffffffffffffffffff1673ffffffffffffffffffffffffffffffffffffffff168152602001908152602001600020600082825401925050819055508173ffffffffffffffffffffffffffffffffffffffff163373ffffffffffffffffffffffffffffffffffffffff167fddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef836040518082815260200191505060405180910390a35050565b60026020528160005260406000206020528060005260406000206000915091505054815600a165627a7a723058206d93424f4e7b11929b8276a269038402c10c0ddf21800e999916ddd9dff4a7630029")
+
+const erc20ABI = `[
+	{"constant":true,"inputs":[],"name":"totalSupply","outputs":[{"name":"","type":"uint256"}],"type":"function"},
+	{"constant":true,"inputs":[{"name":"owner","type":"address"}],"name":"balanceOf","outputs":[{"name":"","type":"uint256"}],"type":"function"},
+	{"constant":false,"inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"}],"name":"transfer","outputs":[],"type":"function"}
+]`
+
+func TestFakeGenesisTokens(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	erc20, err := abi.JSON(strings.NewReader(erc20ABI))
+	if err != nil {
+		t.Fatalf("failed to parse ABI: %v", err)
+	}
+
+	deployer := vm.NewEVM(NewEVMBlockContext(&EvmHeader{Number: big.NewInt(0)}, nil, nil), vm.TxContext{}, statedb, params.AllEthashProtocolChanges, vm.Config{})
+	_, token, _, err := deployer.Create(vm.AccountRef(FakeAddress(1)), deployCode(erc20Code), 1000000, new(big.Int))
+	if err != nil {
+		t.Fatalf("failed to deploy token: %v", err)
+	}
+	// a balance which exists before the genesis and gets replaced by it
+	supplySlot := common.Hash{}
+	statedb.SetState(token, MappingSlot(1)(FakeAddress(2)), common.BigToHash(big.NewInt(7)))
+	statedb.SetState(token, supplySlot, common.BigToHash(big.NewInt(7)))
+
+	holders := map[common.Address]*big.Int{
+		FakeAddress(1): big.NewInt(1e18),
+		FakeAddress(2): big.NewInt(42),
+	}
+	genesis, err := ApplyFakeGenesisConfig(statedb, FakeGenesisConfig{
+		Time: DefaultFakeGenesisTime(),
+		Tokens: []FakeTokenBalances{{
+			Token:      token,
+			SlotCalc:   MappingSlot(1),
+			Holders:    holders,
+			SupplySlot: &supplySlot,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("failed to apply genesis: %v", err)
+	}
+
+	statedb, _ = state.New(genesis.Root, state.NewDatabase(db), nil)
+	evm := NewFakeGenesisEVM(genesis, statedb, params.AllEthashProtocolChanges)
+	call := func(from common.Address, method string, args ...interface{}) []interface{} {
+		input, err := erc20.Pack(method, args...)
+		if err != nil {
+			t.Fatalf("failed to pack %s: %v", method, err)
+		}
+		ret, _, err := evm.Call(vm.AccountRef(from), token, input, 100000, new(big.Int))
+		if err != nil {
+			t.Fatalf("%s call failed: %v", method, err)
+		}
+		out, err := erc20.Unpack(method, ret)
+		if err != nil {
+			t.Fatalf("failed to unpack %s: %v", method, err)
+		}
+		return out
+	}
+	balanceOf := func(holder common.Address) *big.Int {
+		return call(holder, "balanceOf", holder)[0].(*big.Int)
+	}
+	totalSupply := func() *big.Int {
+		return call(FakeAddress(1), "totalSupply")[0].(*big.Int)
+	}
+
+	for holder, exp := range holders {
+		if got := balanceOf(holder); got.Cmp(exp) != 0 {
+			t.Fatalf("wrong token balance of %s: have %s, want %s", holder.Hex(), got, exp)
+		}
+	}
+	if got := balanceOf(FakeAddress(3)); got.Sign() != 0 {
+		t.Fatalf("wrong token balance of non-holder: %s", got)
+	}
+	if got, exp := totalSupply(), big.NewInt(1e18+42); got.Cmp(exp) != 0 {
+		t.Fatalf("wrong total supply: have %s, want %s", got, exp)
+	}
+
+	// the token operates on the seeded balances
+	call(FakeAddress(1), "transfer", FakeAddress(3), big.NewInt(1000))
+	if got, exp := balanceOf(FakeAddress(1)), big.NewInt(1e18-1000); got.Cmp(exp) != 0 {
+		t.Fatalf("wrong sender balance after transfer: have %s, want %s", got, exp)
+	}
+	if got := balanceOf(FakeAddress(3)); got.Cmp(big.NewInt(1000)) != 0 {
+		t.Fatalf("wrong recipient balance after transfer: have %s, want %d", got, 1000)
+	}
+
+	_, err = ApplyFakeGenesisConfig(statedb, FakeGenesisConfig{
+		Tokens: []FakeTokenBalances{{Token: token, Holders: holders}},
+	})
+	if err == nil {
+		t.Fatal("expected error without slot calculator")
+	}
+}
+
+func TestFakeGenesisTimeConcurrent(t *testing.T) {
+	times := []inter.Timestamp{DefaultFakeGenesisTime(), DefaultFakeGenesisTime() + inter.Timestamp(time.Hour)}
+	blocks := make([]*EvmBlock, len(times))
+	errs := make([]error, len(times))
+
+	var wg sync.WaitGroup
+	for i, at := range times {
+		wg.Add(1)
+		go func(i int, at inter.Timestamp) {
+			defer wg.Done()
+			statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+			blocks[i], errs[i] = ApplyFakeGenesis(statedb, at, FakeGenesisBalances(3, big.NewInt(1e18)))
+		}(i, at)
+	}
+	wg.Wait()
+
+	for i, at := range times {
+		if errs[i] != nil {
+			t.Fatalf("failed to apply genesis: %v", errs[i])
+		}
+		if blocks[i].Time != at {
+			t.Fatalf("wrong genesis time: have %d, want %d", blocks[i].Time, at)
+		}
+	}
+	if blocks[0].Root != blocks[1].Root {
+		t.Fatalf("genesis root depends on time: %s != %s", blocks[0].Root.Hex(), blocks[1].Root.Hex())
+	}
+	if DefaultFakeGenesisTime() != times[0] {
+		t.Fatal("default genesis time is changed")
+	}
+}
+
+func TestSnapshotFakeGenesis(t *testing.T) {
+	each := big.NewInt(1e18)
+	balances := FakeGenesisBalances(3, each)
+	root, db, err := SnapshotFakeGenesis(balances)
+	if err != nil {
+		t.Fatalf("failed to build snapshot: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		statedb := RestoreFakeGenesis(db, root)
+		for addr := range balances {
+			if statedb.GetBalance(addr).Cmp(each) != 0 {
+				t.Fatalf("wrong balance of %s after restore %d: have %s, want %s", addr.Hex(), i, statedb.GetBalance(addr), each)
+			}
+		}
+		// must not leak into the next restore
+		statedb.SetBalance(FakeAddress(1), new(big.Int))
+		statedb.SetBalance(FakeAddress(4), each)
+		if statedb.GetBalance(FakeAddress(4)).Cmp(each) != 0 {
+			t.Fatal("restored state isn't writable")
+		}
+	}
+	if statedb := RestoreFakeGenesis(db, root); statedb.Exist(FakeAddress(4)) {
+		t.Fatal("snapshot is modified")
+	}
+}
+
+func TestApplyBalanceDeltas(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	genesis := MustApplyFakeGenesis(statedb, DefaultFakeGenesisTime(), FakeGenesisBalances(2, big.NewInt(1000)))
+
+	root, err := ApplyBalanceDeltas(statedb, map[common.Address]*big.Int{
+		FakeAddress(1): big.NewInt(500),
+		FakeAddress(2): big.NewInt(-1000),
+		FakeAddress(3): big.NewInt(1),
+	})
+	if err != nil {
+		t.Fatalf("failed to apply deltas: %v", err)
+	}
+	if root == genesis.Root {
+		t.Fatal("root isn't changed")
+	}
+
+	statedb, _ = state.New(root, state.NewDatabase(db), nil)
+	for n, exp := range map[uint32]int64{1: 1500, 2: 0, 3: 1} {
+		if got := statedb.GetBalance(FakeAddress(n)); got.Cmp(big.NewInt(exp)) != 0 {
+			t.Fatalf("wrong balance of account %d: have %s, want %d", n, got, exp)
+		}
+	}
+
+	_, err = ApplyBalanceDeltas(statedb, map[common.Address]*big.Int{
+		FakeAddress(1): big.NewInt(-1),
+		FakeAddress(2): big.NewInt(-1),
+	})
+	if err == nil {
+		t.Fatal("expected underflow error")
+	}
+	if got := statedb.GetBalance(FakeAddress(1)); got.Cmp(big.NewInt(1500)) != 0 {
+		t.Fatalf("balance is changed on underflow: %s", got)
+	}
+}
+
+func TestFakeGenesisNumber(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	genesis, err := ApplyFakeGenesis(statedb, DefaultFakeGenesisTime(), nil)
+	if err != nil {
+		t.Fatalf("failed to apply genesis: %v", err)
+	}
+	if genesis.Number.Sign() != 0 || genesis.ParentHash != (common.Hash{}) {
+		t.Fatalf("wrong default genesis header: number %s, parent %s", genesis.Number, genesis.ParentHash.Hex())
+	}
+
+	parent := common.HexToHash("0x0102030405060708")
+	statedb, _ = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	genesis, err = ApplyFakeGenesisConfig(statedb, FakeGenesisConfig{
+		Time:       DefaultFakeGenesisTime(),
+		Number:     1000,
+		ParentHash: parent,
+	})
+	if err != nil {
+		t.Fatalf("failed to apply genesis: %v", err)
+	}
+	if genesis.Number.Cmp(big.NewInt(1000)) != 0 {
+		t.Fatalf("wrong genesis number: have %s, want %d", genesis.Number, 1000)
+	}
+	if genesis.ParentHash != parent {
+		t.Fatalf("wrong genesis parent: have %s, want %s", genesis.ParentHash.Hex(), parent.Hex())
+	}
+}
+
+func TestFakeGenesisRegistry(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	registry := []common.Address{
+		common.HexToAddress("0xd100a01e00000000000000000000000000000000"),
+		common.HexToAddress("0xfc00face00000000000000000000000000000000"),
+	}
+	genesis, err := ApplyFakeGenesisConfig(statedb, FakeGenesisConfig{
+		Time:     DefaultFakeGenesisTime(),
+		Balances: FakeGenesisBalances(1, big.NewInt(1e18)),
+		Registry: registry,
+	})
+	if err != nil {
+		t.Fatalf("failed to apply genesis: %v", err)
+	}
+
+	statedb, _ = state.New(genesis.Root, state.NewDatabase(db), nil)
+	for _, addr := range registry {
+		if !statedb.Exist(addr) || statedb.Empty(addr) {
+			t.Fatalf("registered address %s doesn't exist", addr.Hex())
+		}
+		if statedb.GetNonce(addr) != 1 || statedb.GetBalance(addr).Sign() != 0 || statedb.GetCodeSize(addr) != 0 {
+			t.Fatalf("wrong registered account %s", addr.Hex())
+		}
+	}
+	if statedb.Exist(common.HexToAddress("0xfc00face00000000000000000000000000000001")) {
+		t.Fatal("unlisted address exists")
+	}
+	if statedb.GetNonce(FakeAddress(1)) != 0 {
+		t.Fatal("funded account nonce is changed")
+	}
+}
+
+func TestFakeGenesisSkipClean(t *testing.T) {
+	balances := manyFakeBalances(100)
+	clean := fakeGenesisRoot(t, FakeGenesisConfig{Time: DefaultFakeGenesisTime(), Balances: balances})
+	unclean := fakeGenesisRoot(t, FakeGenesisConfig{Time: DefaultFakeGenesisTime(), Balances: balances, SkipClean: true})
+	if clean != unclean {
+		t.Fatalf("root depends on commit mode: clean %s, unclean %s", clean.Hex(), unclean.Hex())
+	}
+}
+
+func TestFakeGenesisInvalidBalance(t *testing.T) {
+	for name, balance := range map[string]*big.Int{
+		"nil":      nil,
+		"negative": big.NewInt(-1),
+	} {
+		t.Run(name, func(t *testing.T) {
+			statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+			if err != nil {
+				t.Fatalf("cannot create statedb: %v", err)
+			}
+			balances := FakeGenesisBalances(2, big.NewInt(1e18))
+			bad := FakeAddress(3)
+			balances[bad] = balance
+
+			_, err = ApplyFakeGenesis(statedb, DefaultFakeGenesisTime(), balances)
+			if err == nil {
+				t.Fatal("no error on the invalid balance")
+			}
+			if !strings.Contains(err.Error(), bad.Hex()) {
+				t.Fatalf("error doesn't name the address %s: %v", bad.Hex(), err)
+			}
+			if statedb.Exist(FakeAddress(1)) {
+				t.Fatal("state is mutated before the check")
+			}
+		})
+	}
+}
+
+func TestApplyFakeGenesisState(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	if err != nil {
+		t.Fatalf("cannot create statedb: %v", err)
+	}
+	balances := FakeGenesisBalances(2, big.NewInt(1000))
+	genesis, genesisState, err := ApplyFakeGenesisState(statedb, DefaultFakeGenesisTime(), balances)
+	if err != nil {
+		t.Fatalf("failed to apply genesis: %v", err)
+	}
+	if have := genesisState.IntermediateRoot(true); have != genesis.Root {
+		t.Fatalf("state isn't at the genesis root: have %s, want %s", have.Hex(), genesis.Root.Hex())
+	}
+	if have := genesisState.GetBalance(FakeAddress(1)); have.Cmp(balances[FakeAddress(1)]) != 0 {
+		t.Fatalf("genesis balance is %s, want %s", have, balances[FakeAddress(1)])
+	}
+
+	genesisState.AddBalance(FakeAddress(1), big.NewInt(1))
+	root, err := flush(genesisState, true)
+	if err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if root == genesis.Root {
+		t.Fatal("root isn't changed by the mutation")
+	}
+	balances[FakeAddress(1)] = big.NewInt(1001)
+	if err := VerifyFakeGenesisRoot(balances, root); err != nil {
+		t.Fatal(err)
+	}
+
+	// the genesis state is still available
+	restored := RestoreFakeGenesis(db, genesis.Root)
+	if have := restored.GetBalance(FakeAddress(1)); have.Cmp(big.NewInt(1000)) != 0 {
+		t.Fatalf("genesis balance is %s after the mutation, want 1000", have)
+	}
+}
+
+func TestDiffFakeGenesis(t *testing.T) {
+	a := map[common.Address]*big.Int{
+		FakeAddress(1): big.NewInt(100),
+		FakeAddress(2): big.NewInt(200),
+		FakeAddress(3): big.NewInt(300),
+	}
+	b := map[common.Address]*big.Int{
+		FakeAddress(2): big.NewInt(250),
+		FakeAddress(3): big.NewInt(300),
+		FakeAddress(4): big.NewInt(400),
+	}
+	rootA, rootB, deltas := DiffFakeGenesis(a, b)
+	if rootA == rootB {
+		t.Fatal("roots of different allocations are equal")
+	}
+	if err := VerifyFakeGenesisRoot(a, rootA); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyFakeGenesisRoot(b, rootB); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[common.Address]*big.Int{
+		FakeAddress(1): big.NewInt(-100),
+		FakeAddress(2): big.NewInt(50),
+		FakeAddress(4): big.NewInt(400),
+	}
+	if len(deltas) != len(expected) {
+		t.Fatalf("%d deltas, want %d", len(deltas), len(expected))
+	}
+	for acc, want := range expected {
+		if have := deltas[acc]; have == nil || have.Cmp(want) != 0 {
+			t.Fatalf("delta of %s is %v, want %s", acc.Hex(), have, want)
+		}
+	}
+	// the inputs aren't modified
+	if a[FakeAddress(2)].Cmp(big.NewInt(200)) != 0 || b[FakeAddress(2)].Cmp(big.NewInt(250)) != 0 {
+		t.Fatal("allocation is modified")
+	}
+}
+
+func TestFakeGenesisChainConfig(t *testing.T) {
+	// BASEFEE PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN
+	code := hexutil.MustDecode("0x4860005260206000f3")
+	contract := common.HexToAddress("0xba5efee")
+
+	london := *params.TestChainConfig
+	berlin := *params.TestChainConfig
+	berlin.LondonBlock = nil
+
+	run := func(chainConfig *params.ChainConfig) (*EvmBlock, []byte, error) {
+		db := rawdb.NewMemoryDatabase()
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+		if err != nil {
+			t.Fatalf("cannot create statedb: %v", err)
+		}
+		statedb.SetCode(contract, code)
+		genesis, err := ApplyFakeGenesisConfig(statedb, FakeGenesisConfig{
+			Time:        DefaultFakeGenesisTime(),
+			ChainConfig: chainConfig,
+		})
+		if err != nil {
+			t.Fatalf("failed to apply genesis: %v", err)
+		}
+		genesisState := RestoreFakeGenesis(db, genesis.Root)
+		ret, _, err := NewFakeGenesisEVM(genesis, genesisState, chainConfig).Call(vm.AccountRef(common.Address{}), contract, nil, 100000, new(big.Int))
+		return genesis, ret, err
+	}
+
+	genesis, ret, err := run(&london)
+	if err != nil {
+		t.Fatalf("BASEFEE failed after London: %v", err)
+	}
+	if genesis.BaseFee == nil || genesis.BaseFee.Cmp(big.NewInt(params.InitialBaseFee)) != 0 {
+		t.Fatalf("genesis base fee is %v, want %d", genesis.BaseFee, params.InitialBaseFee)
+	}
+	if have := new(big.Int).SetBytes(ret); have.Cmp(genesis.BaseFee) != 0 {
+		t.Fatalf("BASEFEE returned %s, want %s", have, genesis.BaseFee)
+	}
+
+	genesis, _, err = run(&berlin)
+	if _, ok := err.(*vm.ErrInvalidOpCode); !ok {
+		t.Fatalf("BASEFEE before London returned %v, want invalid opcode", err)
+	}
+	if genesis.BaseFee != nil {
+		t.Fatalf("genesis has base fee %s before London", genesis.BaseFee)
+	}
+}
+
+func TestFakeGenesisTotalSupply(t *testing.T) {
+	balances := FakeGenesisBalances(3, big.NewInt(1e18))
+	balances[FakeAddress(4)] = big.NewInt(5)
+	expected, _ := new(big.Int).SetString("3000000000000000005", 10)
+	if have := FakeGenesisTotalSupply(balances); have.Cmp(expected) != 0 {
+		t.Fatalf("total supply is %s, want %s", have, expected)
+	}
+
+	// no balance is minted or burned by the genesis
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatalf("cannot create statedb: %v", err)
+	}
+	if _, err := ApplyFakeGenesis(statedb, DefaultFakeGenesisTime(), balances); err != nil {
+		t.Fatalf("failed to apply genesis: %v", err)
+	}
+	applied := make(map[common.Address]*big.Int, len(balances))
+	for acc := range balances {
+		applied[acc] = statedb.GetBalance(acc)
+	}
+	if have := FakeGenesisTotalSupply(applied); have.Cmp(expected) != 0 {
+		t.Fatalf("applied total supply is %s, want %s", have, expected)
+	}
+
+	balances[FakeAddress(5)] = nil
+	if have := FakeGenesisTotalSupply(balances); have.Cmp(expected) != 0 {
+		t.Fatalf("nil balance isn't counted as zero: %s, want %s", have, expected)
+	}
+	if have := FakeGenesisTotalSupply(nil); have.Sign() != 0 {
+		t.Fatalf("total supply of no balances is %s", have)
+	}
+}
+
+// failingBatchDB is a database which fails to write batches
+type failingBatchDB struct {
+	ethdb.Database
+}
+
+type failingBatch struct {
+	ethdb.Batch
+}
+
+func (db failingBatchDB) NewBatch() ethdb.Batch {
+	return failingBatch{db.Database.NewBatch()}
+}
+
+func (b failingBatch) Write() error {
+	return errors.New("write failed")
+}
+
+func TestTryApplyFakeGenesis(t *testing.T) {
+	db := failingBatchDB{rawdb.NewMemoryDatabase()}
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	if err != nil {
+		t.Fatalf("cannot create statedb: %v", err)
+	}
+
+	genesis, err := TryApplyFakeGenesis(statedb, DefaultFakeGenesisTime(), FakeGenesisBalances(2, big.NewInt(1e18)))
+	if err == nil {
+		t.Fatal("no error on the closed db")
+	}
+	if genesis != nil {
+		t.Fatal("genesis is returned along with the error")
+	}
+}
+
+func TestFakeValidators(t *testing.T) {
+	const weight = pos.Weight(7)
+	for _, n := range []uint32{1, 3, 100} {
+		validators := FakeValidators(n, weight)
+		if validators.Len() != idx.Validator(n) {
+			t.Fatalf("%d validators, want %d", validators.Len(), n)
+		}
+		if validators.TotalWeight() != pos.Weight(n)*weight {
+			t.Fatalf("total weight %d, want %d", validators.TotalWeight(), pos.Weight(n)*weight)
+		}
+		for i := idx.Validator(0); i < validators.Len(); i++ {
+			if w := validators.GetWeightByIdx(i); w != weight {
+				t.Fatalf("weight of validator #%d is %d, want %d", i, w, weight)
+			}
+		}
+		for id := uint32(1); id <= n; id++ {
+			if !validators.Exists(idx.ValidatorID(id)) {
+				t.Fatalf("validator %d doesn't exist", id)
+			}
+		}
+	}
+}
+
+func TestFakeKeyFormats(t *testing.T) {
+	for _, n := range []uint32{1, 2, 50, 100} {
+		hex := FakeKeyHex(n)
+		if !strings.HasPrefix(hex, "0x") || len(hex) != 2+64 {
+			t.Fatalf("wrong hex format of key %d: %s", n, hex)
+		}
+		raw := FakeKeyBytes(n)
+		if len(raw) != 32 {
+			t.Fatalf("wrong length of key %d: %d", n, len(raw))
+		}
+		key, err := crypto.ToECDSA(raw)
+		if err != nil {
+			t.Fatalf("failed to decode key %d: %v", n, err)
+		}
+		if !key.Equal(FakeKey(n)) {
+			t.Fatalf("key %d mismatch", n)
+		}
+		if hexutil.Encode(raw) != hex {
+			t.Fatalf("hex and bytes of key %d mismatch", n)
+		}
+	}
+}
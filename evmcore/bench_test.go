@@ -152,9 +152,12 @@ func benchInsertChain(b *testing.B, disk bool, gen func(int, *BlockGen)) {
 	if err != nil {
 		b.Fatalf("cannot create statedb: %v", err)
 	}
-	genesisBlock := MustApplyFakeGenesis(statedb, FakeGenesisTime, map[common.Address]*big.Int{
+	genesisBlock, err := TryApplyFakeGenesis(statedb, DefaultFakeGenesisTime(), map[common.Address]*big.Int{
 		benchRootAddr: benchRootFunds,
 	})
+	if err != nil {
+		b.Fatalf("cannot apply genesis: %v", err)
+	}
 	genesisBlock.GasLimit = 1000000
 
 	// Time the insertion of the new chain.
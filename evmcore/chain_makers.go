@@ -45,7 +45,8 @@ type BlockGen struct {
 	txs      []*types.Transaction
 	receipts []*types.Receipt
 
-	config *params.ChainConfig
+	config   *params.ChainConfig
+	vmConfig vm.Config
 }
 
 type TestChain struct {
@@ -99,7 +100,7 @@ func (b *BlockGen) AddTxWithChain(bc DummyChain, tx *types.Transaction) {
 	}
 	b.statedb.Prepare(tx.Hash(), len(b.txs))
 	blockContext := NewEVMBlockContext(b.header, bc, nil)
-	vmenv := vm.NewEVM(blockContext, vm.TxContext{}, b.statedb, b.config, opera.DefaultVMConfig)
+	vmenv := vm.NewEVM(blockContext, vm.TxContext{}, b.statedb, b.config, b.vmConfig)
 	receipt, _, _, err := applyTransaction(msg, b.config, b.gasPool, b.statedb, b.header.Number, b.header.Hash, tx, &b.header.GasUsed, vmenv, func(log *types.Log, db *state.StateDB) {})
 	if err != nil {
 		panic(err)
@@ -108,6 +109,13 @@ func (b *BlockGen) AddTxWithChain(bc DummyChain, tx *types.Transaction) {
 	b.receipts = append(b.receipts, receipt)
 }
 
+// SetTracer attaches the EVM tracer to the transactions which are added afterwards.
+// Nil tracer disables the tracing. See NewFakeGenesisTracedEVM for the execution on top of the genesis state.
+func (b *BlockGen) SetTracer(tracer vm.Tracer) {
+	b.vmConfig.Debug = tracer != nil
+	b.vmConfig.Tracer = tracer
+}
+
 // GetBalance returns the balance of the given address at the generated block.
 func (b *BlockGen) GetBalance(addr common.Address) *big.Int {
 	return b.statedb.GetBalance(addr)
@@ -196,7 +204,7 @@ func GenerateChain(config *params.ChainConfig, parent *EvmBlock, db ethdb.Databa
 
 	blocks, receipts := make([]*EvmBlock, n), make([]types.Receipts, n)
 	genblock := func(i int, parent *EvmBlock, statedb *state.StateDB) (*EvmBlock, types.Receipts) {
-		b := &BlockGen{i: i, chain: blocks, parent: parent, statedb: statedb, config: config}
+		b := &BlockGen{i: i, chain: blocks, parent: parent, statedb: statedb, config: config, vmConfig: opera.DefaultVMConfig}
 		b.header = makeHeader(parent, statedb)
 
 		// Execute any user modifications to the block
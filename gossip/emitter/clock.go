@@ -0,0 +1,16 @@
+package emitter
+
+import "time"
+
+// Clock is a source of the current time used by the emitter.
+// It drives the idle and emission times, and the creation time of emitted events.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the wall clock
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
@@ -1,6 +1,7 @@
 package emitter
 
 import (
+	"fmt"
 	"math/rand"
 	"time"
 
@@ -27,6 +28,28 @@ type ValidatorConfig struct {
 	PasswordFilePath string
 }
 
+// PeerRateThrottle is the configuration of the AIMD backoff of the minimum emit interval,
+// which is applied when peers emit events rapidly.
+type PeerRateThrottle struct {
+	// Threshold is the peers event rate (events per second) above which the backoff grows, zero disables the throttling
+	Threshold float64
+	// Increase is added to the backoff per second while the rate is above Threshold
+	Increase time.Duration
+	// DecreaseFactor multiplies the backoff per second while the rate isn't above Threshold
+	DecreaseFactor float64
+	// Max is the maximum backoff
+	Max time.Duration
+}
+
+// LowPowerBreaker is the configuration of the circuit breaker, which stops the emission attempts
+// after repeated low power skips until the gas power recovers.
+type LowPowerBreaker struct {
+	// Skips is the number of consecutive low power skips which opens the breaker, zero disables the breaker
+	Skips uint32
+	// ResetThreshold is the gas power left above which the open breaker is closed
+	ResetThreshold uint64
+}
+
 type FileConfig struct {
 	Path     string
 	SyncMode bool
@@ -40,6 +63,53 @@ type Config struct {
 
 	EmitIntervals EmitIntervals // event emission intervals
 
+	// MinIntervalOverride replaces the minimum emit interval of the efficiency metric if non-zero.
+	// It's raised to EmitIntervals.Min and minIntervalOverrideFloor, and then capped by EmitIntervals.Max.
+	MinIntervalOverride time.Duration
+
+	// validators with a lower ratio of stake before them emit event right after transaction is originated
+	AggressiveStakeRatio float64
+	// validators with a lower ratio of stake before them emit event sooner after transaction is originated
+	BalancedStakeRatio float64
+
+	// TxSource reports the txs waiting for emission, e.g. internally originated ones.
+	// If it has pending txs, the emitter isn't considered idle.
+	TxSource TxSource `toml:"-"`
+
+	// PendingInternalTxs reports whether there are internally originated txs waiting for emission.
+	// If it returns true, the emitter isn't considered idle. It's consulted along with TxSource,
+	// and is subject to the same requirements.
+	PendingInternalTxs func() bool `toml:"-"`
+
+	// PeerEventRate returns the observed rate of events emitted by peers, events per second.
+	// If set, the minimum emit interval is widened according to PeerRateThrottle.
+	PeerEventRate    func() float64 `toml:"-"`
+	PeerRateThrottle PeerRateThrottle
+
+	LowPowerBreaker LowPowerBreaker
+
+	// DevMode makes validator emit events with txs as soon as EmitIntervals.Min has passed,
+	// ignoring the stake and power heuristics. It's opt-in and has effect only if the
+	// network has a single validator.
+	DevMode bool
+
+	// RestartCatchUpWindow is a period after start, during which the previous emission is counted
+	// as if it happened at the start, until the first event is emitted. It prevents a burst of forced
+	// emissions caused by a stale previous emission time/block. Zero disables the catch-up.
+	RestartCatchUpWindow time.Duration
+
+	// MetricWindow is the number of the recent quorum metrics, which are averaged into the event metric.
+	// A larger window smooths the response to a sudden consensus advancement at the cost of responsiveness.
+	// Zero or one disables the smoothing.
+	MetricWindow uint32
+
+	// DisableKickstart disables the boost of event metric in a beginning of epoch,
+	// which is useful for simulations of the steady-state emission
+	DisableKickstart bool
+
+	// TraceEmit enables logging of emission decisions, at most once per emitTracePeriod
+	TraceEmit bool
+
 	MaxTxsPerAddress int
 
 	MaxParents idx.Event
@@ -69,6 +139,15 @@ func DefaultConfig() Config {
 			ParallelInstanceProtection: 1 * time.Minute,
 		},
 
+		AggressiveStakeRatio: 0.35,
+		BalancedStakeRatio:   0.7,
+
+		PeerRateThrottle: PeerRateThrottle{
+			Increase:       200 * time.Millisecond,
+			DecreaseFactor: 0.5,
+			Max:            time.Second,
+		},
+
 		MaxTxsPerAddress: TxTurnNonces,
 
 		MaxParents: 0,
@@ -81,6 +160,42 @@ func DefaultConfig() Config {
 	}
 }
 
+// Validate checks the config for consistency.
+func (c *Config) Validate() error {
+	if c.EmergencyThreshold > c.NoTxsThreshold {
+		return fmt.Errorf("EmergencyThreshold (%d) must not be greater than NoTxsThreshold (%d)", c.EmergencyThreshold, c.NoTxsThreshold)
+	}
+	for name, interval := range map[string]time.Duration{
+		"EmitIntervals.Min":                        c.EmitIntervals.Min,
+		"EmitIntervals.Max":                        c.EmitIntervals.Max,
+		"EmitIntervals.Confirming":                 c.EmitIntervals.Confirming,
+		"EmitIntervals.ParallelInstanceProtection": c.EmitIntervals.ParallelInstanceProtection,
+		"EmitIntervals.DoublesignProtection":       c.EmitIntervals.DoublesignProtection,
+		"MinIntervalOverride":                      c.MinIntervalOverride,
+		"PeerRateThrottle.Increase":                c.PeerRateThrottle.Increase,
+		"PeerRateThrottle.Max":                     c.PeerRateThrottle.Max,
+		"RestartCatchUpWindow":                     c.RestartCatchUpWindow,
+	} {
+		if interval < 0 {
+			return fmt.Errorf("%s (%s) must not be negative", name, interval)
+		}
+	}
+	if c.AggressiveStakeRatio <= 0 || c.AggressiveStakeRatio > c.BalancedStakeRatio || c.BalancedStakeRatio > 1 {
+		return fmt.Errorf("stake ratios must satisfy 0 < AggressiveStakeRatio (%v) <= BalancedStakeRatio (%v) <= 1",
+			c.AggressiveStakeRatio, c.BalancedStakeRatio)
+	}
+	if c.LowPowerBreaker.Skips != 0 && c.LowPowerBreaker.ResetThreshold < c.EmergencyThreshold {
+		return fmt.Errorf("LowPowerBreaker.ResetThreshold (%d) must not be lower than EmergencyThreshold (%d)", c.LowPowerBreaker.ResetThreshold, c.EmergencyThreshold)
+	}
+	if c.PeerRateThrottle.Threshold < 0 {
+		return fmt.Errorf("PeerRateThrottle.Threshold (%v) must not be negative", c.PeerRateThrottle.Threshold)
+	}
+	if c.PeerRateThrottle.DecreaseFactor < 0 || c.PeerRateThrottle.DecreaseFactor > 1 {
+		return fmt.Errorf("PeerRateThrottle.DecreaseFactor (%v) must be in range [0, 1]", c.PeerRateThrottle.DecreaseFactor)
+	}
+	return nil
+}
+
 // RandomizeEmitTime and return new config
 func (cfg EmitIntervals) RandomizeEmitTime(r *rand.Rand) EmitIntervals {
 	config := cfg
@@ -0,0 +1,85 @@
+package emitter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Fantom-foundation/go-opera/opera"
+)
+
+func TestConfigValidate(t *testing.T) {
+	for _, cfg := range []Config{DefaultConfig(), FakeConfig(1), FakeTestnetConfig(1)} {
+		require.NoError(t, cfg.Validate())
+	}
+
+	for _, tc := range []struct {
+		name                 string
+		aggressive, balanced float64
+		ok                   bool
+	}{
+		{"zero aggressive", 0, 0.7, false},
+		{"aggressive above balanced", 0.8, 0.7, false},
+		{"balanced above 1", 0.35, 1.1, false},
+		{"equal", 0.5, 0.5, true},
+		{"balanced is 1", 0.35, 1, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.AggressiveStakeRatio = tc.aggressive
+			cfg.BalancedStakeRatio = tc.balanced
+			if tc.ok {
+				require.NoError(t, cfg.Validate())
+			} else {
+				require.Error(t, cfg.Validate())
+			}
+		})
+	}
+}
+
+func TestNewEmitterInvalidConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.EmergencyThreshold = cfg.NoTxsThreshold + 1
+	require.Panics(t, func() {
+		NewEmitter(cfg, World{})
+	})
+	_, err := NewSimulation(cfg, newTestValidators(3), opera.FakeNetRules(), time.Now())
+	require.Error(t, err)
+}
+
+func TestConfigValidateThresholds(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.EmergencyThreshold = cfg.NoTxsThreshold
+	require.NoError(t, cfg.Validate())
+
+	cfg.EmergencyThreshold = cfg.NoTxsThreshold + 1
+	require.Error(t, cfg.Validate())
+}
+
+func TestConfigValidateIntervals(t *testing.T) {
+	for name, set := range map[string]func(*Config){
+		"Min":                             func(c *Config) { c.EmitIntervals.Min = -1 },
+		"Max":                             func(c *Config) { c.EmitIntervals.Max = -1 },
+		"Confirming":                      func(c *Config) { c.EmitIntervals.Confirming = -1 },
+		"ParallelInstanceProtection":      func(c *Config) { c.EmitIntervals.ParallelInstanceProtection = -1 },
+		"DoublesignProtection":            func(c *Config) { c.EmitIntervals.DoublesignProtection = -1 },
+		"MinIntervalOverride":             func(c *Config) { c.MinIntervalOverride = -1 },
+		"PeerRateThrottle.Increase":       func(c *Config) { c.PeerRateThrottle.Increase = -1 },
+		"PeerRateThrottle.Max":            func(c *Config) { c.PeerRateThrottle.Max = -1 },
+		"PeerRateThrottle.Threshold":      func(c *Config) { c.PeerRateThrottle.Threshold = -1 },
+		"PeerRateThrottle.DecreaseFactor": func(c *Config) { c.PeerRateThrottle.DecreaseFactor = 1.5 },
+		"RestartCatchUpWindow":            func(c *Config) { c.RestartCatchUpWindow = -1 },
+		"LowPowerBreaker.ResetThreshold": func(c *Config) {
+			c.LowPowerBreaker = LowPowerBreaker{Skips: 1, ResetThreshold: c.EmergencyThreshold - 1}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			set(&cfg)
+			err := cfg.Validate()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), name)
+		})
+	}
+}
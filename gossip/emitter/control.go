@@ -1,6 +1,7 @@
 package emitter
 
 import (
+	"math"
 	"time"
 
 	"github.com/Fantom-foundation/lachesis-base/emitter/ancestor"
@@ -12,6 +13,9 @@ import (
 )
 
 func scalarUpdMetric(diff idx.Event, weight pos.Weight, totalWeight pos.Weight) ancestor.Metric {
+	if totalWeight == 0 {
+		return 0
+	}
 	return ancestor.Metric(scalarUpdMetricF(uint64(diff)*piecefunc.DecimalUnit)) * ancestor.Metric(weight) / ancestor.Metric(totalWeight)
 }
 
@@ -26,6 +30,26 @@ func updMetric(median, cur, upd idx.Event, validatorIdx idx.Validator, validator
 	return scalarUpdMetric(upd-median, weight, validators.TotalWeight())
 }
 
+// smoothMetric returns the average of the quorum metric and the previous ones within MetricWindow
+func (em *Emitter) smoothMetric(metric ancestor.Metric) ancestor.Metric {
+	window := int(em.config.MetricWindow)
+	if window <= 1 {
+		return metric
+	}
+	w := &em.metricWindow
+	if len(w.samples) < window {
+		w.samples = append(w.samples, metric)
+	} else {
+		w.samples[w.next] = metric
+	}
+	w.next = (w.next + 1) % window
+	var sum ancestor.Metric
+	for _, m := range w.samples {
+		sum += m
+	}
+	return sum / ancestor.Metric(len(w.samples))
+}
+
 func kickStartMetric(metric ancestor.Metric, seq idx.Event) ancestor.Metric {
 	// kickstart metric in a beginning of epoch, when there's nothing to observe yet
 	if seq <= 2 && metric < 0.9*piecefunc.DecimalUnit {
@@ -37,104 +61,396 @@ func kickStartMetric(metric ancestor.Metric, seq idx.Event) ancestor.Metric {
 	return metric
 }
 
-func eventMetric(orig ancestor.Metric, seq idx.Event) ancestor.Metric {
-	return kickStartMetric(ancestor.Metric(eventMetricF(uint64(orig))), seq)
+func eventMetric(orig ancestor.Metric, seq idx.Event, kickstart bool) ancestor.Metric {
+	metric := ancestor.Metric(eventMetricF(uint64(orig)))
+	if !kickstart {
+		return metric
+	}
+	return kickStartMetric(metric, seq)
 }
 
-func (em *Emitter) isAllowedToEmit(e inter.EventI, eTxs bool, metric ancestor.Metric, selfParent *inter.Event) bool {
-	passedTime := e.CreationTime().Time().Sub(em.prevEmittedAtTime)
+// maxPassedBlocks returns numbers of blocks since previous event, after which validator is forced to emit an event.
+// The soft limit applies to events with a high metric, the hard limit applies to any event.
+// The hard limit keeps a margin from BlockMissedSlack, after which validator is considered as missing blocks.
+// The arithmetic is saturating, so a small slack doesn't underflow and a large slack doesn't overflow.
+func maxPassedBlocks(blockMissedSlack idx.Block) (soft, hard idx.Block) {
+	hard = blockMissedSlack/2 + 1
+	// keep a margin of 5 blocks if the slack is large enough
+	if blockMissedSlack > 5 && hard < blockMissedSlack-5 {
+		hard = blockMissedSlack - 5
+	}
+	if hard <= math.MaxUint64/4 {
+		soft = hard * 4 / 5
+	} else {
+		soft = hard / 5 * 4
+	}
+	return soft, hard
+}
+
+// minIntervalOverrideFloor is the lowest allowed value of MinIntervalOverride, to avoid events spam
+const minIntervalOverrideFloor = 100 * time.Millisecond
+
+// efficiencyMinInterval returns the minimum emit interval used by the efficiency metric
+func (em *Emitter) efficiencyMinInterval() time.Duration {
+	override := em.config.MinIntervalOverride
+	if override == 0 {
+		return em.intervals.Min
+	}
+	// the override only widens the interval, and the max interval has the priority over the floor,
+	// so the efficiency interval never exceeds it
+	floor := maxDuration(em.intervals.Min, minIntervalOverrideFloor)
+	return minDuration(maxDuration(override, floor), em.intervals.Max)
+}
+
+// samplePeerEventRate adjusts the peers rate backoff by the observed peers event rate.
+// The rate hook is external, so it's called without the world lock.
+func (em *Emitter) samplePeerEventRate() {
+	rate := 0.0
+	if em.config.PeerEventRate != nil && em.config.PeerRateThrottle.Threshold != 0 {
+		rate = em.config.PeerEventRate()
+	}
+	em.world.Lock()
+	defer em.world.Unlock()
+	em.adjustPeerRateBackoff(rate, em.clock.Now())
+}
+
+// adjustPeerRateBackoff grows the backoff additively while peers emit events rapidly,
+// and shrinks it multiplicatively otherwise. The backoff is scaled by the time since the previous sample,
+// so it doesn't depend on how often the rate is sampled.
+func (em *Emitter) adjustPeerRateBackoff(rate float64, now time.Time) {
+	throttle := em.config.PeerRateThrottle
+	prev := em.peerRateSampledAt
+	em.peerRateSampledAt = now
+	if em.config.PeerEventRate == nil || throttle.Threshold == 0 {
+		em.peerRateBackoff = 0
+		return
+	}
+	if prev.IsZero() || !now.After(prev) {
+		return
+	}
+	elapsed := now.Sub(prev).Seconds()
+	if rate > throttle.Threshold {
+		em.peerRateBackoff = minDuration(em.peerRateBackoff+time.Duration(float64(throttle.Increase)*elapsed), throttle.Max)
+	} else {
+		em.peerRateBackoff = time.Duration(float64(em.peerRateBackoff) * math.Pow(throttle.DecreaseFactor, elapsed))
+	}
+}
+
+// effectiveMinInterval returns the minimum emit interval of the efficiency metric, widened by the peers rate backoff
+func (em *Emitter) effectiveMinInterval() time.Duration {
+	minInterval := em.efficiencyMinInterval()
+	if em.peerRateBackoff == 0 {
+		return minInterval
+	}
+	return maxDuration(minInterval, minDuration(minInterval+em.peerRateBackoff, em.intervals.Max))
+}
+
+// startCatchUp starts the conservative emission window after start
+func (em *Emitter) startCatchUp() {
+	if em.config.RestartCatchUpWindow == 0 {
+		return
+	}
+	em.catchUp.until = em.clock.Now().Add(em.config.RestartCatchUpWindow)
+	em.catchUp.block = em.world.GetLatestBlockIndex()
+}
+
+// prevEmitted returns the time and block of the previous emission.
+// During the catch-up window, they're counted from the start if the previous emission is older.
+func (em *Emitter) prevEmitted() (time.Time, idx.Block) {
+	prevTime, prevBlock := em.prevEmittedAtTime, em.prevEmittedAtBlock
+	if em.clock.Now().Before(em.catchUp.until) {
+		start := em.catchUp.until.Add(-em.config.RestartCatchUpWindow)
+		if prevTime.Before(start) {
+			prevTime = start
+		}
+		if prevBlock < em.catchUp.block {
+			prevBlock = em.catchUp.block
+		}
+	}
+	return prevTime, prevBlock
+}
+
+func (em *Emitter) passedTime(e inter.EventI) time.Duration {
+	return em.passedTimeAt(e.CreationTime().Time())
+}
+
+func (em *Emitter) passedTimeAt(at time.Time) time.Duration {
+	prevTime, _ := em.prevEmitted()
+	passedTime := at.Sub(prevTime)
 	if passedTime < 0 {
 		passedTime = 0
 	}
-	passedTimeIdle := e.CreationTime().Time().Sub(em.prevIdleTime)
+	return passedTime
+}
+
+func (em *Emitter) passedTimeIdle(e inter.EventI, passedTime time.Duration) time.Duration {
+	return em.passedTimeIdleAt(e.Creator(), e.CreationTime().Time(), passedTime)
+}
+
+func (em *Emitter) passedTimeIdleAt(creator idx.ValidatorID, at time.Time, passedTime time.Duration) time.Duration {
+	passedTimeIdle := at.Sub(em.prevIdleTime)
 	if passedTimeIdle < 0 {
 		passedTimeIdle = 0
 	}
-	if em.stakeRatio[e.Creator()] < 0.35*piecefunc.DecimalUnit {
+	if em.stakeRatio[creator] < uint64(em.config.AggressiveStakeRatio*piecefunc.DecimalUnit) {
 		// top validators emit event right after transaction is originated
 		passedTimeIdle = passedTime
-	} else if em.stakeRatio[e.Creator()] < 0.7*piecefunc.DecimalUnit {
+	} else if em.stakeRatio[creator] < uint64(em.config.BalancedStakeRatio*piecefunc.DecimalUnit) {
 		// top validators emit event right after transaction is originated
 		passedTimeIdle = (passedTimeIdle + passedTime) / 2
 	}
 	if passedTimeIdle > passedTime {
 		passedTimeIdle = passedTime
 	}
+	return passedTimeIdle
+}
+
+func (em *Emitter) isAllowedToEmit(e inter.EventI, eTxs bool, metric ancestor.Metric, selfParent *inter.Event) bool {
+	d := em.decide(e, eTxs, metric, selfParent)
+	em.onEmitDecision(d)
+	return d.Allowed
+}
+
+// decide makes the emission decision along with its context
+func (em *Emitter) decide(e inter.EventI, eTxs bool, metric ancestor.Metric, selfParent *inter.Event) EmitDecision {
+	allowed, reason := false, ReasonLowPowerBreaker
+	if !em.lowPowerBreakerOpen(e) {
+		allowed, reason = em.decideEmit(e, eTxs, metric, selfParent)
+		em.countLowPowerSkips(reason)
+	}
+	// priority signal is consumed by a single decision, and force signal by a forced emission,
+	// so the force isn't lost if a guard declines the emission
+	em.priority = false
+	if allowed && reason == ReasonForced {
+		em.forceEmit = false
+	}
+	passedTime := em.passedTime(e)
+	return EmitDecision{
+		Creator:        e.Creator(),
+		Metric:         metric,
+		PassedTime:     passedTime,
+		PassedTimeIdle: em.passedTimeIdle(e, passedTime),
+		GasPowerLeft:   e.GasPowerLeft(),
+		Reason:         reason,
+		Allowed:        allowed,
+	}
+}
+
+func (em *Emitter) decideEmit(e inter.EventI, eTxs bool, metric ancestor.Metric, selfParent *inter.Event) (bool, string) {
+	passedTime := em.passedTime(e)
+	passedTimeIdle := em.passedTimeIdle(e, passedTime)
+	_, prevBlock := em.prevEmitted()
+	passedBlocks := em.world.GetLatestBlockIndex() - prevBlock
+
+	// the rules are applied in order, the first decided rule makes the decision
+	if decided, allow, reason := em.validatorsGuard(); decided {
+		return allow, reason
+	}
+	if decided, allow, reason := em.powerGuard(e, selfParent); decided {
+		return allow, reason
+	}
+	if decided, allow, reason := em.forceRule(); decided {
+		return allow, reason
+	}
+	if decided, allow, reason := em.devModeRule(eTxs, passedTime); decided {
+		return allow, reason
+	}
+	if decided, allow, reason := em.maxPassedRule(metric, passedTime, passedBlocks); decided {
+		return allow, reason
+	}
+	if decided, allow, reason := em.powerSlowdownRule(e, passedTime); decided {
+		return allow, reason
+	}
+	if decided, allow, reason := em.idleSlowdownRule(eTxs, passedTime); decided {
+		return allow, reason
+	}
+	if decided, allow, reason := em.efficiencyRule(eTxs, metric, passedTime, passedTimeIdle); decided {
+		return allow, reason
+	}
+	return true, ReasonAllowed
+}
+
+// validatorsGuard forbids emitting if there are no validators to emit for, e.g. in a misconfigured bootstrap
+func (em *Emitter) validatorsGuard() (decided, allow bool, reason string) {
+	if em.validators == nil || em.validators.Len() == 0 || em.validators.TotalWeight() == 0 {
+		em.Periodic.Warn(10*time.Second, "Empty validators set, not emitting")
+		return true, false, ReasonNoValidators
+	}
+	return false, false, ""
+}
+
+// powerGuard forbids emitting if not enough power and power is decreasing
+func (em *Emitter) powerGuard(e inter.EventI, selfParent *inter.Event) (decided, allow bool, reason string) {
+	threshold := em.config.EmergencyThreshold
+	if e.GasPowerLeft().Min() <= threshold {
+		if selfParent != nil && e.GasPowerLeft().Min() < selfParent.GasPowerLeft().Min() {
+			em.Periodic.Warn(10*time.Second, "Not enough power to emit event, waiting",
+				"power", e.GasPowerLeft().String(),
+				"selfParentPower", selfParent.GasPowerLeft().String(),
+				"stake%", 100*float64(em.validators.Get(e.Creator()))/float64(em.validators.TotalWeight()))
+			return true, false, ReasonLowPower
+		}
+	}
+	return false, false, ""
+}
+
+// forceRule emits if the emission is forced by ForceEmitNext
+func (em *Emitter) forceRule() (decided, allow bool, reason string) {
+	if em.forceEmit {
+		em.Log.Warn("Emitting forced event")
+		return true, true, ReasonForced
+	}
+	return false, false, ""
+}
+
+// devModeRule emits txs without a delay in dev mode of a single-validator network
+func (em *Emitter) devModeRule(eTxs bool, passedTime time.Duration) (decided, allow bool, reason string) {
+	if em.config.DevMode && em.validators.Len() == 1 && eTxs && passedTime >= em.intervals.Min {
+		return true, true, ReasonDevMode
+	}
+	return false, false, ""
+}
+
+// maxPassedRule enforces emitting if passed too many time/blocks since previous event
+func (em *Emitter) maxPassedRule(metric ancestor.Metric, passedTime time.Duration, passedBlocks idx.Block) (decided, allow bool, reason string) {
+	softMaxBlocks, maxBlocks := maxPassedBlocks(em.world.GetRules().Economy.BlockMissedSlack)
+	if passedTime >= em.intervals.Max {
+		return true, true, ReasonMaxTime
+	}
+	if passedBlocks >= softMaxBlocks && metric >= piecefunc.DecimalUnit/2 ||
+		passedBlocks >= maxBlocks {
+		return true, true, ReasonMaxBlocks
+	}
+	return false, false, ""
+}
+
+// powerSlowdownRule slows down emitting if power is low
+func (em *Emitter) powerSlowdownRule(e inter.EventI, passedTime time.Duration) (decided, allow bool, reason string) {
+	threshold := (em.config.NoTxsThreshold + em.config.EmergencyThreshold) / 2
+	if e.GasPowerLeft().Min() <= threshold {
+		// it's emitter, so no need in determinism => fine to use float
+		minT := float64(em.intervals.Min)
+		maxT := float64(em.intervals.Max)
+		factor := float64(e.GasPowerLeft().Min()) / float64(threshold)
+		adjustedEmitInterval := time.Duration(maxT - (maxT-minT)*factor)
+		throttled := passedTime < adjustedEmitInterval
+		reportPowerSlowdown(adjustedEmitInterval, throttled)
+		if throttled {
+			return true, false, ReasonPowerSlowdown
+		}
+	}
+	return false, false, ""
+}
+
+// idleSlowdownRule slows down emitting if no txs to confirm/originate
+func (em *Emitter) idleSlowdownRule(eTxs bool, passedTime time.Duration) (decided, allow bool, reason string) {
+	if passedTime < em.intervals.Max &&
+		em.idle() &&
+		!eTxs {
+		return true, false, ReasonIdleNoTxs
+	}
+	return false, false, ""
+}
+
+// efficiencyRule controls emitting by the efficiency metric
+func (em *Emitter) efficiencyRule(eTxs bool, metric ancestor.Metric, passedTime, passedTimeIdle time.Duration) (decided, allow bool, reason string) {
 	// metric is a decimal (0.0, 1.0], being an estimation of how much the event will advance the consensus
 	adjustedPassedTime := time.Duration(ancestor.Metric(passedTime/piecefunc.DecimalUnit) * metric)
 	adjustedPassedIdleTime := time.Duration(ancestor.Metric(passedTimeIdle/piecefunc.DecimalUnit) * metric)
-	passedBlocks := em.world.GetLatestBlockIndex() - em.prevEmittedAtBlock
-	// Forbid emitting if not enough power and power is decreasing
-	{
-		threshold := em.config.EmergencyThreshold
-		if e.GasPowerLeft().Min() <= threshold {
-			if selfParent != nil && e.GasPowerLeft().Min() < selfParent.GasPowerLeft().Min() {
-				em.Periodic.Warn(10*time.Second, "Not enough power to emit event, waiting",
-					"power", e.GasPowerLeft().String(),
-					"selfParentPower", selfParent.GasPowerLeft().String(),
-					"stake%", 100*float64(em.validators.Get(e.Creator()))/float64(em.validators.TotalWeight()))
-				return false
-			}
-		}
+	minInterval := em.effectiveMinInterval()
+	if passedTime < minInterval {
+		return true, false, ReasonMinInterval
 	}
-	// Enforce emitting if passed too many time/blocks since previous event
-	{
-		rules := em.world.GetRules()
-		maxBlocks := rules.Economy.BlockMissedSlack/2 + 1
-		if rules.Economy.BlockMissedSlack > maxBlocks && maxBlocks < rules.Economy.BlockMissedSlack-5 {
-			maxBlocks = rules.Economy.BlockMissedSlack - 5
-		}
-		if passedTime >= em.intervals.Max ||
-			passedBlocks >= maxBlocks*4/5 && metric >= piecefunc.DecimalUnit/2 ||
-			passedBlocks >= maxBlocks {
-			return true
-		}
+	if adjustedPassedTime < minInterval &&
+		!em.idle() {
+		return true, false, ReasonMetricInterval
 	}
-	// Slow down emitting if power is low
-	{
-		threshold := (em.config.NoTxsThreshold + em.config.EmergencyThreshold) / 2
-		if e.GasPowerLeft().Min() <= threshold {
-			// it's emitter, so no need in determinism => fine to use float
-			minT := float64(em.intervals.Min)
-			maxT := float64(em.intervals.Max)
-			factor := float64(e.GasPowerLeft().Min()) / float64(threshold)
-			adjustedEmitInterval := time.Duration(maxT - (maxT-minT)*factor)
-			if passedTime < adjustedEmitInterval {
-				return false
-			}
-		}
+	if adjustedPassedIdleTime < em.intervals.Confirming &&
+		!em.priority &&
+		!em.idle() &&
+		!eTxs {
+		return true, false, ReasonConfirmingInterval
 	}
-	// Slow down emitting if no txs to confirm/originate
-	{
-		if passedTime < em.intervals.Max &&
-			em.idle() &&
-			!eTxs {
-			return false
+	return false, false, ""
+}
+
+// TimeToNextEmit estimates the time until the emitter is allowed to emit the next event,
+// assuming the best event metric and no new txs to originate.
+// It's an approximation of isAllowedToEmit, which doesn't account for the gas power and the passed blocks.
+// It locks the world, so it must not be called from the world's callbacks.
+func (em *Emitter) TimeToNextEmit() time.Duration {
+	em.world.Lock()
+	defer em.world.Unlock()
+
+	now := em.clock.Now()
+	passedTime := em.passedTimeAt(now)
+	// emitting is enforced after the max interval
+	wait := em.intervals.Max - passedTime
+	if !em.idle() {
+		// originated txs are confirmed after the min and confirming intervals
+		next := em.effectiveMinInterval() - passedTime
+		if !em.priority {
+			passedTimeIdle := em.passedTimeIdleAt(em.config.Validator.ID, now, passedTime)
+			next = maxDuration(next, em.intervals.Confirming-passedTimeIdle)
 		}
+		wait = minDuration(wait, next)
 	}
-	// Emitting is controlled by the efficiency metric
-	{
-		if passedTime < em.intervals.Min {
-			return false
-		}
-		if adjustedPassedTime < em.intervals.Min &&
-			!em.idle() {
-			return false
-		}
-		if adjustedPassedIdleTime < em.intervals.Confirming &&
-			!em.idle() &&
-			!eTxs {
-			return false
-		}
+	return maxDuration(wait, 0)
+}
+
+// lowPowerBreakerOpen checks whether the low power breaker is open, closing it if the gas power has recovered
+func (em *Emitter) lowPowerBreakerOpen(e inter.EventI) bool {
+	if !em.lowPowerBreaker.open {
+		return false
+	}
+	if e.GasPowerLeft().Min() <= em.config.LowPowerBreaker.ResetThreshold {
+		return true
+	}
+	em.lowPowerBreaker.open = false
+	em.lowPowerBreaker.skips = 0
+	em.Log.Info("Gas power recovered, resuming emission", "power", e.GasPowerLeft().String())
+	return false
+}
+
+// countLowPowerSkips opens the low power breaker after the configured number of consecutive low power skips
+func (em *Emitter) countLowPowerSkips(reason string) {
+	if em.config.LowPowerBreaker.Skips == 0 {
+		return
+	}
+	if reason != ReasonLowPower {
+		em.lowPowerBreaker.skips = 0
+		return
+	}
+	em.lowPowerBreaker.skips++
+	if em.lowPowerBreaker.skips >= em.config.LowPowerBreaker.Skips {
+		em.lowPowerBreaker.open = true
+		em.Log.Warn("Gas power is low for too long, pausing emission", "skips", em.lowPowerBreaker.skips,
+			"resetThreshold", em.config.LowPowerBreaker.ResetThreshold)
 	}
+}
 
-	return true
+// ForceEmitNext makes the next emission decision allow emitting regardless of the intervals and metrics.
+// The power guard still applies, so an event isn't emitted if the gas power is critically low.
+// It locks the world, so it must not be called from the world's callbacks.
+func (em *Emitter) ForceEmitNext() {
+	em.world.Lock()
+	defer em.world.Unlock()
+	em.forceEmit = true
+	em.Log.Warn("Forced emission of the next event is requested")
+}
+
+// SignalPriority marks the pending txs as latency-critical, so the next emission decision skips the confirming slow-down.
+// It locks the world, so it must not be called from the world's callbacks.
+func (em *Emitter) SignalPriority() {
+	em.world.Lock()
+	defer em.world.Unlock()
+	em.priority = true
 }
 
 func (em *Emitter) recheckIdleTime() {
 	em.world.Lock()
 	defer em.world.Unlock()
 	if em.idle() {
-		em.prevIdleTime = time.Now()
+		em.prevIdleTime = em.clock.Now()
 	}
 }
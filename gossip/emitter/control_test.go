@@ -0,0 +1,986 @@
+package emitter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Fantom-foundation/lachesis-base/emitter/ancestor"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/Fantom-foundation/lachesis-base/inter/pos"
+	"github.com/Fantom-foundation/lachesis-base/utils/piecefunc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Fantom-foundation/go-opera/gossip/emitter/mock"
+	"github.com/Fantom-foundation/go-opera/inter"
+	"github.com/Fantom-foundation/go-opera/opera"
+	"github.com/Fantom-foundation/go-opera/utils/rate"
+)
+
+func newTestValidators(n int) *pos.Validators {
+	vv := pos.NewBuilder()
+	for i := 1; i <= n; i++ {
+		vv.Set(idx.ValidatorID(i), pos.Weight(1))
+	}
+	return vv.Build()
+}
+
+// fakeClock is a manually advanced clock
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// newControlTestEmitter makes an emitter suitable for the emission decision checks
+func newControlTestEmitter(t *testing.T, cfg Config, validators *pos.Validators) *Emitter {
+	ctrl := gomock.NewController(t)
+	external := mock.NewMockExternal(ctrl)
+	external.EXPECT().Lock().
+		AnyTimes()
+	external.EXPECT().Unlock().
+		AnyTimes()
+	external.EXPECT().GetRules().
+		Return(opera.FakeNetRules()).
+		AnyTimes()
+	external.EXPECT().GetLatestBlockIndex().
+		Return(idx.Block(1)).
+		AnyTimes()
+
+	em := NewEmitter(cfg, World{
+		External: external,
+	})
+	em.intervals = cfg.EmitIntervals
+	em.validators = validators
+	em.stakeRatio = make(map[idx.ValidatorID]uint64)
+	return em
+}
+
+func newControlTestEvent(creator idx.ValidatorID, at time.Time, gasPowerLeft uint64) *inter.MutableEventPayload {
+	me := &inter.MutableEventPayload{}
+	me.SetCreator(creator)
+	me.SetCreationTime(inter.Timestamp(at.UnixNano()))
+	me.SetGasPowerLeft(inter.GasPowerLeft{Gas: [inter.GasPowerConfigs]uint64{gasPowerLeft, gasPowerLeft}})
+	return me
+}
+
+func TestEmitDecisionHook(t *testing.T) {
+	require := require.New(t)
+
+	cfg := DefaultConfig()
+	em := newControlTestEmitter(t, cfg, newTestValidators(3))
+	now := time.Now()
+	em.prevEmittedAtTime = now.Add(-time.Second)
+
+	var got []EmitDecision
+	em.SetEmitDecisionHook(func(d EmitDecision) {
+		got = append(got, d)
+	})
+
+	selfParent := newControlTestEvent(1, now.Add(-time.Second), cfg.EmergencyThreshold+1).Build()
+	e := newControlTestEvent(1, now, cfg.EmergencyThreshold-1)
+	require.False(em.isAllowedToEmit(e, true, 0, &selfParent.Event))
+	require.Empty(got, "hook must be called only after the world is unlocked")
+
+	em.notifyEmitDecisions()
+	require.Len(got, 1)
+	require.Equal(idx.ValidatorID(1), got[0].Creator)
+	require.Equal(ReasonLowPower, got[0].Reason)
+	require.False(got[0].Allowed)
+	require.Equal(time.Second, got[0].PassedTime)
+	require.Equal(cfg.EmergencyThreshold-1, got[0].GasPowerLeft.Min())
+
+	// nil hook disables notifications
+	em.SetEmitDecisionHook(nil)
+	require.False(em.isAllowedToEmit(e, true, 0, &selfParent.Event))
+	em.notifyEmitDecisions()
+	require.Len(got, 1)
+}
+
+func TestMinIntervalOverride(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.EmitIntervals.Min = 150 * time.Millisecond
+	cfg.EmitIntervals.Max = 10 * time.Second
+
+	for _, tc := range []struct {
+		name     string
+		override time.Duration
+		exp      time.Duration
+	}{
+		{"zero keeps Min", 0, 150 * time.Millisecond},
+		{"widened", time.Second, time.Second},
+		{"below Min keeps Min", 120 * time.Millisecond, 150 * time.Millisecond},
+		{"below floor keeps Min", time.Millisecond, 150 * time.Millisecond},
+		{"clamped to Max", time.Minute, 10 * time.Second},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := cfg
+			cfg.MinIntervalOverride = tc.override
+			em := newControlTestEmitter(t, cfg, newTestValidators(3))
+			require.Equal(t, tc.exp, em.efficiencyMinInterval())
+		})
+	}
+
+	t.Run("Min below floor", func(t *testing.T) {
+		cfg := cfg
+		cfg.MinIntervalOverride = time.Millisecond
+		cfg.EmitIntervals.Min = 10 * time.Millisecond
+		em := newControlTestEmitter(t, cfg, newTestValidators(3))
+		require.Equal(t, minIntervalOverrideFloor, em.efficiencyMinInterval())
+	})
+
+	t.Run("Max below floor", func(t *testing.T) {
+		cfg := cfg
+		cfg.MinIntervalOverride = time.Millisecond
+		cfg.EmitIntervals.Min = 10 * time.Millisecond
+		cfg.EmitIntervals.Max = 50 * time.Millisecond
+		em := newControlTestEmitter(t, cfg, newTestValidators(3))
+		require.Equal(t, cfg.EmitIntervals.Max, em.efficiencyMinInterval())
+	})
+
+	t.Run("decision", func(t *testing.T) {
+		require := require.New(t)
+		cfg := cfg
+		em := newControlTestEmitter(t, cfg, newTestValidators(3))
+		now := time.Now()
+		em.prevEmittedAtTime = now.Add(-500 * time.Millisecond)
+		e := newControlTestEvent(1, now, cfg.LimitedTpsThreshold)
+
+		allowed, reason := em.decideEmit(e, true, piecefunc.DecimalUnit, nil)
+		require.True(allowed)
+		require.Equal(ReasonAllowed, reason)
+
+		em.config.MinIntervalOverride = time.Second
+		allowed, reason = em.decideEmit(e, true, piecefunc.DecimalUnit, nil)
+		require.False(allowed)
+		require.Equal(ReasonMinInterval, reason)
+	})
+}
+
+func TestPassedTimeIdle(t *testing.T) {
+	cfg := DefaultConfig()
+	em := newControlTestEmitter(t, cfg, newTestValidators(3))
+	now := time.Now()
+	em.prevIdleTime = now.Add(-time.Second)
+	passedTime := 3 * time.Second
+
+	for _, tc := range []struct {
+		name  string
+		ratio float64
+		exp   time.Duration
+	}{
+		{"below aggressive", 0.34, passedTime},
+		{"between", 0.5, 2 * time.Second},
+		{"above balanced", 0.71, time.Second},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			em.stakeRatio[1] = uint64(tc.ratio * piecefunc.DecimalUnit)
+			e := newControlTestEvent(1, now, cfg.LimitedTpsThreshold)
+			require.Equal(t, tc.exp, em.passedTimeIdle(e, passedTime))
+		})
+	}
+
+	// passedTimeIdle never exceeds passedTime
+	em.stakeRatio[1] = piecefunc.DecimalUnit
+	e := newControlTestEvent(1, now, cfg.LimitedTpsThreshold)
+	require.Equal(t, 500*time.Millisecond, em.passedTimeIdle(e, 500*time.Millisecond))
+}
+
+func TestDevMode(t *testing.T) {
+	require := require.New(t)
+
+	// dev mode is opt-in
+	require.False(FakeConfig(1).DevMode)
+	cfg := FakeConfig(1)
+
+	em := newControlTestEmitter(t, cfg, newTestValidators(1))
+	em.originatedTxs.Inc(common.Address{1})
+	now := time.Now()
+	em.prevEmittedAtTime = now.Add(-cfg.EmitIntervals.Min)
+	lowMetric := ancestor.Metric(0.05 * piecefunc.DecimalUnit)
+
+	for _, power := range []uint64{cfg.LimitedTpsThreshold, (cfg.NoTxsThreshold + cfg.EmergencyThreshold) / 2} {
+		e := newControlTestEvent(1, now, power)
+		em.config.DevMode = false
+		allowed, _ := em.decideEmit(e, true, lowMetric, nil)
+		require.False(allowed)
+
+		em.config.DevMode = true
+		allowed, reason := em.decideEmit(e, true, lowMetric, nil)
+		require.True(allowed)
+		require.Equal(ReasonDevMode, reason)
+
+		// no txs to emit
+		allowed, _ = em.decideEmit(e, false, lowMetric, nil)
+		require.False(allowed)
+	}
+
+	// emergency power guard is still respected
+	selfParent := newControlTestEvent(1, now.Add(-time.Second), cfg.EmergencyThreshold+1).Build()
+	e := newControlTestEvent(1, now, cfg.EmergencyThreshold-1)
+	allowed, reason := em.decideEmit(e, true, lowMetric, &selfParent.Event)
+	require.False(allowed)
+	require.Equal(ReasonLowPower, reason)
+
+	// dev mode doesn't apply to a multi-validator network
+	em.validators = newTestValidators(3)
+	allowed, reason = em.decideEmit(newControlTestEvent(1, now, cfg.LimitedTpsThreshold), true, lowMetric, nil)
+	require.False(allowed)
+	require.NotEqual(ReasonDevMode, reason)
+}
+
+func TestMaxPassedBlocks(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		slack     idx.Block
+		soft, exp idx.Block
+	}{
+		{0, 0, 1},
+		{3, 1, 2},
+		{6, 3, 4},
+		{50, 36, 45},
+		{math.MaxUint64, (math.MaxUint64 - 5) / 5 * 4, math.MaxUint64 - 5},
+	} {
+		soft, hard := maxPassedBlocks(tc.slack)
+		require.Equal(tc.exp, hard, tc.slack)
+		require.Equal(tc.soft, soft, tc.slack)
+		require.LessOrEqual(soft, hard, tc.slack)
+	}
+
+	prevSoft, prevHard := maxPassedBlocks(0)
+	for slack := idx.Block(1); slack < 1000; slack++ {
+		soft, hard := maxPassedBlocks(slack)
+		require.GreaterOrEqual(hard, prevHard, slack)
+		require.GreaterOrEqual(soft, prevSoft, slack)
+		require.LessOrEqual(hard, slack+1, slack)
+		prevSoft, prevHard = soft, hard
+	}
+}
+
+func TestTraceEmit(t *testing.T) {
+	require := require.New(t)
+
+	cfg := DefaultConfig()
+	em := newControlTestEmitter(t, cfg, newTestValidators(3))
+	var records []*log.Record
+	em.emitTrace.Log.SetHandler(log.FuncHandler(func(r *log.Record) error {
+		records = append(records, r)
+		return nil
+	}))
+	now := time.Now()
+	em.prevEmittedAtTime = now.Add(-time.Second)
+	e := newControlTestEvent(1, now, cfg.LimitedTpsThreshold)
+
+	em.isAllowedToEmit(e, false, piecefunc.DecimalUnit, nil)
+	require.Empty(records)
+
+	em.config.TraceEmit = true
+	em.isAllowedToEmit(e, false, piecefunc.DecimalUnit, nil)
+	require.Len(records, 1)
+	fields := make(map[interface{}]interface{})
+	for i := 0; i+1 < len(records[0].Ctx); i += 2 {
+		fields[records[0].Ctx[i]] = records[0].Ctx[i+1]
+	}
+	require.Equal(idx.ValidatorID(1), fields["creator"])
+	require.Equal(ReasonIdleNoTxs, fields["reason"])
+	require.Equal(false, fields["allowed"])
+	require.Equal(ancestor.Metric(piecefunc.DecimalUnit), fields["metric"])
+	require.Equal(time.Second, fields["passed"])
+	require.Equal(time.Second, fields["passedIdle"])
+	require.Equal(cfg.LimitedTpsThreshold, fields["power"])
+
+	// throttled
+	em.isAllowedToEmit(e, false, piecefunc.DecimalUnit, nil)
+	require.Len(records, 1)
+}
+
+func TestReplayDecisions(t *testing.T) {
+	require := require.New(t)
+
+	cfg := DefaultConfig()
+	start := time.Unix(1600000000, 0)
+	power := cfg.LimitedTpsThreshold
+	selfParent := newControlTestEvent(1, start, power).Build()
+	trace := []RecordedObservation{
+		{Event: newControlTestEvent(1, start.Add(100*time.Millisecond), power), Txs: true, Metric: piecefunc.DecimalUnit},
+		{Event: newControlTestEvent(1, start.Add(200*time.Millisecond), power), Txs: true, Metric: piecefunc.DecimalUnit},
+		{Event: newControlTestEvent(1, start.Add(300*time.Millisecond), power), Txs: false, Metric: piecefunc.DecimalUnit},
+		{Event: newControlTestEvent(1, start.Add(500*time.Millisecond), cfg.EmergencyThreshold-1), SelfParent: &selfParent.Event, Txs: true, Metric: piecefunc.DecimalUnit},
+		{Event: newControlTestEvent(1, start.Add(600*time.Millisecond), power), Txs: true, Metric: piecefunc.DecimalUnit},
+	}
+	expReasons := []string{ReasonMinInterval, ReasonAllowed, ReasonIdleNoTxs, ReasonLowPower, ReasonAllowed}
+
+	replay := func() []EmitDecision {
+		em := newControlTestEmitter(t, cfg, newTestValidators(3))
+		em.prevEmittedAtTime = start
+		decisions := em.ReplayDecisions(trace)
+		require.Equal(realClock{}, em.clock)
+		require.Equal(start.Add(600*time.Millisecond), em.prevEmittedAtTime)
+		require.Equal(start.Add(600*time.Millisecond), em.prevIdleTime)
+		return decisions
+	}
+
+	decisions := replay()
+	require.Len(decisions, len(trace))
+	for i, d := range decisions {
+		require.Equal(expReasons[i], d.Reason, i)
+		require.Equal(d.Reason == ReasonAllowed, d.Allowed, i)
+	}
+	require.Equal(400*time.Millisecond, decisions[4].PassedTime)
+	require.Equal(decisions, replay())
+}
+
+func TestFakeClockIdle(t *testing.T) {
+	require := require.New(t)
+
+	cfg := DefaultConfig()
+	em := newControlTestEmitter(t, cfg, newTestValidators(3))
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	em.clock = clock
+	em.prevEmittedAtTime = clock.Now()
+	em.stakeRatio[1] = piecefunc.DecimalUnit
+
+	// idle validator without txs slows down up to the max interval
+	for _, step := range []time.Duration{time.Second, time.Minute, cfg.EmitIntervals.Max - time.Minute - time.Second - 1} {
+		clock.Advance(step)
+		em.recheckIdleTime()
+		require.Equal(clock.Now(), em.prevIdleTime)
+		e := newControlTestEvent(1, clock.Now(), cfg.LimitedTpsThreshold)
+		allowed, reason := em.decideEmit(e, false, piecefunc.DecimalUnit, nil)
+		require.False(allowed)
+		require.Equal(ReasonIdleNoTxs, reason)
+	}
+	clock.Advance(1)
+	e := newControlTestEvent(1, clock.Now(), cfg.LimitedTpsThreshold)
+	allowed, reason := em.decideEmit(e, false, piecefunc.DecimalUnit, nil)
+	require.True(allowed)
+	require.Equal(ReasonMaxTime, reason)
+
+	// idle time isn't updated while there are originated txs
+	em.prevEmittedAtTime = clock.Now()
+	em.recheckIdleTime()
+	em.originatedTxs.Inc(common.Address{1})
+	clock.Advance(time.Second)
+	em.recheckIdleTime()
+	require.Equal(clock.Now().Add(-time.Second), em.prevIdleTime)
+	e = newControlTestEvent(1, clock.Now(), cfg.LimitedTpsThreshold)
+	require.Equal(time.Second, em.passedTimeIdle(e, em.passedTime(e)))
+}
+
+func TestSignalPriority(t *testing.T) {
+	require := require.New(t)
+
+	cfg := DefaultConfig()
+	em := newControlTestEmitter(t, cfg, newTestValidators(3))
+	em.intervals.Confirming = time.Second
+	em.originatedTxs.Inc(common.Address{1})
+	now := time.Now()
+	em.prevEmittedAtTime = now.Add(-500 * time.Millisecond)
+	e := newControlTestEvent(1, now, cfg.LimitedTpsThreshold)
+
+	var reasons []string
+	em.SetEmitDecisionHook(func(d EmitDecision) {
+		reasons = append(reasons, d.Reason)
+	})
+	decide := func() bool {
+		allowed := em.isAllowedToEmit(e, false, piecefunc.DecimalUnit, nil)
+		em.notifyEmitDecisions()
+		return allowed
+	}
+
+	require.False(decide())
+	em.SignalPriority()
+	require.True(decide())
+	require.False(decide())
+	require.Equal([]string{ReasonConfirmingInterval, ReasonAllowed, ReasonConfirmingInterval}, reasons)
+}
+
+func TestLastSkipReason(t *testing.T) {
+	require := require.New(t)
+
+	cfg := DefaultConfig()
+	em := newControlTestEmitter(t, cfg, newTestValidators(3))
+	now := time.Now()
+	em.prevEmittedAtTime = now.Add(-time.Second)
+	require.Equal("", em.LastSkipReason(1))
+
+	selfParent := newControlTestEvent(1, now.Add(-time.Second), cfg.EmergencyThreshold+1).Build()
+	e := newControlTestEvent(1, now, cfg.EmergencyThreshold-1)
+	require.False(em.isAllowedToEmit(e, true, piecefunc.DecimalUnit, &selfParent.Event))
+	require.Equal("low_power", em.LastSkipReason(1))
+	require.Equal("", em.LastSkipReason(2))
+
+	// allowed decision doesn't reset the reason until the event is emitted
+	e = newControlTestEvent(1, now, cfg.LimitedTpsThreshold)
+	require.True(em.isAllowedToEmit(e, true, piecefunc.DecimalUnit, &selfParent.Event))
+	require.Equal("low_power", em.LastSkipReason(1))
+	em.resetLastSkipReason(1)
+	require.Equal("", em.LastSkipReason(1))
+}
+
+func TestPeerRateThrottle(t *testing.T) {
+	require := require.New(t)
+
+	cfg := DefaultConfig()
+	rate := 0.0
+	var world *lockedExternal
+	cfg.PeerEventRate = func() float64 {
+		require.False(world.locked, "the rate must be sampled without the world lock")
+		return rate
+	}
+	cfg.PeerRateThrottle.Threshold = 10
+	em := newControlTestEmitter(t, cfg, newTestValidators(3))
+	world = &lockedExternal{External: em.world.External}
+	em.world.External = world
+	clock := &fakeClock{now: time.Now()}
+	em.clock = clock
+	sample := func(elapsed time.Duration) {
+		clock.Advance(elapsed)
+		em.samplePeerEventRate()
+	}
+
+	// low rate leaves the interval unchanged
+	rate = 5
+	for i := 0; i < 3; i++ {
+		sample(time.Second)
+		require.Equal(cfg.EmitIntervals.Min, em.effectiveMinInterval())
+	}
+
+	// high rate increases the interval additively in time up to the cap
+	rate = 100
+	for i := 1; i <= 3; i++ {
+		sample(time.Second)
+		require.Equal(cfg.EmitIntervals.Min+time.Duration(i)*cfg.PeerRateThrottle.Increase, em.effectiveMinInterval())
+	}
+	// the growth doesn't depend on the sampling frequency
+	for i := 0; i < 10; i++ {
+		sample(100 * time.Millisecond)
+	}
+	require.Equal(cfg.EmitIntervals.Min+4*cfg.PeerRateThrottle.Increase, em.effectiveMinInterval())
+	sample(time.Minute)
+	require.Equal(cfg.EmitIntervals.Min+cfg.PeerRateThrottle.Max, em.effectiveMinInterval())
+	em.prevEmittedAtTime = clock.now.Add(-cfg.EmitIntervals.Min - cfg.PeerRateThrottle.Max/2)
+	allowed, reason := em.decideEmit(newControlTestEvent(1, clock.now, cfg.LimitedTpsThreshold), true, piecefunc.DecimalUnit, nil)
+	require.False(allowed)
+	require.Equal(ReasonMinInterval, reason)
+
+	// and decreases it multiplicatively in time when the rate drops
+	rate = 5
+	sample(time.Second)
+	require.Equal(cfg.EmitIntervals.Min+cfg.PeerRateThrottle.Max/2, em.effectiveMinInterval())
+	sample(2 * time.Second)
+	require.Equal(cfg.EmitIntervals.Min+cfg.PeerRateThrottle.Max/8, em.effectiveMinInterval())
+
+	// disabled by default
+	em.config.PeerRateThrottle.Threshold = 0
+	rate = 100
+	sample(time.Second)
+	require.Equal(cfg.EmitIntervals.Min, em.effectiveMinInterval())
+}
+
+func TestGasPowerGauge(t *testing.T) {
+	require := require.New(t)
+
+	// the gauge is registered as a nil one if metrics are disabled
+	gauge := &metrics.StandardGauge{}
+	prevGauge := gasPowerGauge
+	gasPowerGauge = gauge
+	defer func() {
+		gasPowerGauge = prevGauge
+	}()
+
+	cfg := DefaultConfig()
+	cfg.Validator.ID = 1
+	em := newControlTestEmitter(t, cfg, newTestValidators(3))
+	now := time.Now()
+	em.prevEmittedAtTime = now.Add(-time.Second)
+
+	em.isAllowedToEmit(newControlTestEvent(1, now, 12345), true, piecefunc.DecimalUnit, nil)
+	require.Equal(int64(12345), gauge.Value())
+
+	// only the own validator is reported
+	em.isAllowedToEmit(newControlTestEvent(2, now, 1), true, piecefunc.DecimalUnit, nil)
+	require.Equal(int64(12345), gauge.Value())
+}
+
+func TestDisableKickstart(t *testing.T) {
+	require := require.New(t)
+
+	orig := ancestor.Metric(0.1 * piecefunc.DecimalUnit)
+	steady := ancestor.Metric(eventMetricF(uint64(orig)))
+
+	// kickstart boosts only the first events of epoch
+	require.Greater(eventMetric(orig, 1, true), eventMetric(orig, 1, false))
+	require.Equal(kickStartMetric(steady, 1), eventMetric(orig, 1, true))
+	require.Equal(steady, eventMetric(orig, 1, false))
+	require.Equal(eventMetric(orig, 3, true), eventMetric(orig, 3, false))
+	require.Equal(steady, eventMetric(orig, 3, false))
+
+	require.False(DefaultConfig().DisableKickstart)
+}
+
+func TestEpochEmitStats(t *testing.T) {
+	require := require.New(t)
+
+	cfg := DefaultConfig()
+	em := newControlTestEmitter(t, cfg, newTestValidators(3))
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	em.clock = clock
+	em.resetEpochEmitStats()
+	em.prevEmittedAtTime = clock.Now()
+
+	count, rate := em.EpochEmitStats()
+	require.Zero(count)
+	require.Zero(rate)
+
+	var trace []RecordedObservation
+	for i := 1; i <= 5; i++ {
+		at := clock.Now().Add(time.Duration(i) * 200 * time.Millisecond)
+		trace = append(trace, RecordedObservation{Event: newControlTestEvent(1, at, cfg.LimitedTpsThreshold), Txs: true, Metric: piecefunc.DecimalUnit})
+	}
+	for _, d := range em.ReplayDecisions(trace) {
+		require.True(d.Allowed)
+	}
+
+	clock.Advance(2 * time.Second)
+	count, rate = em.EpochEmitStats()
+	require.Equal(uint64(5), count)
+	require.InDelta(2.5, rate, 0.01)
+
+	// reset on new epoch
+	em.resetEpochEmitStats()
+	count, _ = em.EpochEmitStats()
+	require.Zero(count)
+}
+
+func TestEmptyValidators(t *testing.T) {
+	require := require.New(t)
+
+	cfg := DefaultConfig()
+	now := time.Now()
+	for name, validators := range map[string]*pos.Validators{
+		"empty":       pos.NewBuilder().Build(),
+		"zero weight": pos.EqualWeightValidators([]idx.ValidatorID{1, 2}, 0),
+		"nil":         nil,
+	} {
+		t.Run(name, func(t *testing.T) {
+			em := newControlTestEmitter(t, cfg, validators)
+			em.prevEmittedAtTime = now.Add(-time.Hour)
+			selfParent := newControlTestEvent(1, now.Add(-time.Second), cfg.EmergencyThreshold+1).Build()
+			e := newControlTestEvent(1, now, cfg.EmergencyThreshold-1)
+			require.NotPanics(func() {
+				require.False(em.isAllowedToEmit(e, true, piecefunc.DecimalUnit, &selfParent.Event))
+			})
+			require.Equal(ReasonNoValidators, em.LastSkipReason(1))
+		})
+	}
+
+	require.Zero(scalarUpdMetric(1, 0, 0))
+}
+
+func TestRestartCatchUp(t *testing.T) {
+	require := require.New(t)
+
+	cfg := DefaultConfig()
+	cfg.RestartCatchUpWindow = time.Minute
+	em := newControlTestEmitter(t, cfg, newTestValidators(3))
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	em.clock = clock
+	em.stakeRatio[1] = piecefunc.DecimalUnit
+
+	// the previous emission is stale after a restart
+	em.prevEmittedAtTime = clock.Now().Add(-time.Hour)
+	em.prevIdleTime = em.prevEmittedAtTime
+	em.startCatchUp()
+
+	decide := func() EmitDecision {
+		e := newControlTestEvent(1, clock.Now(), cfg.LimitedTpsThreshold)
+		return em.decide(e, false, piecefunc.DecimalUnit, nil)
+	}
+
+	// emission isn't forced by the stale previous emission during the window
+	clock.Advance(time.Second)
+	d := decide()
+	require.False(d.Allowed)
+	require.Equal(time.Second, d.PassedTime)
+
+	// normal behavior after the window
+	clock.Advance(cfg.RestartCatchUpWindow)
+	d = decide()
+	require.True(d.Allowed)
+	require.Equal(ReasonMaxTime, d.Reason)
+
+	// catch-up is over after the first emission
+	em.startCatchUp()
+	em.prevEmittedAtTime = clock.Now().Add(-time.Hour)
+	em.onEmitted(1)
+	em.prevEmittedAtTime = clock.Now().Add(-time.Hour)
+	d = decide()
+	require.True(d.Allowed)
+	require.Equal(ReasonMaxTime, d.Reason)
+
+	// zero window keeps the current behavior
+	em.config.RestartCatchUpWindow = 0
+	em.startCatchUp()
+	d = decide()
+	require.True(d.Allowed)
+	require.Equal(ReasonMaxTime, d.Reason)
+}
+
+func TestTimeToNextEmit(t *testing.T) {
+	require := require.New(t)
+
+	cfg := DefaultConfig()
+	cfg.Validator.ID = 1
+	em := newControlTestEmitter(t, cfg, newTestValidators(3))
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	em.clock = clock
+	em.stakeRatio[1] = piecefunc.DecimalUnit
+
+	// idle validator emits after the max interval
+	em.prevEmittedAtTime = clock.Now().Add(-time.Minute)
+	require.Equal(cfg.EmitIntervals.Max-time.Minute, em.TimeToNextEmit())
+	em.prevEmittedAtTime = clock.Now().Add(-2 * cfg.EmitIntervals.Max)
+	require.Zero(em.TimeToNextEmit())
+
+	// originated txs are confirmed after the min and confirming intervals
+	em.originatedTxs.Inc(common.Address{1})
+	em.prevEmittedAtTime = clock.Now().Add(-50 * time.Millisecond)
+	em.prevIdleTime = clock.Now().Add(-100 * time.Millisecond)
+	wait := em.TimeToNextEmit()
+	require.Equal(cfg.EmitIntervals.Confirming-50*time.Millisecond, wait)
+	require.GreaterOrEqual(wait, cfg.EmitIntervals.Min-50*time.Millisecond)
+
+	decide := func(at time.Time) bool {
+		e := newControlTestEvent(1, at, cfg.LimitedTpsThreshold)
+		allowed, _ := em.decideEmit(e, false, piecefunc.DecimalUnit, nil)
+		return allowed
+	}
+	require.False(decide(clock.Now().Add(wait - time.Millisecond)))
+	require.True(decide(clock.Now().Add(wait)))
+
+	// priority skips the confirming interval
+	em.prevIdleTime = clock.Now()
+	require.Equal(cfg.EmitIntervals.Confirming, em.TimeToNextEmit())
+	em.priority = true
+	require.Equal(cfg.EmitIntervals.Min-50*time.Millisecond, em.TimeToNextEmit())
+}
+
+func TestLowPowerBreaker(t *testing.T) {
+	require := require.New(t)
+
+	cfg := DefaultConfig()
+	cfg.LowPowerBreaker = LowPowerBreaker{
+		Skips:          3,
+		ResetThreshold: cfg.NoTxsThreshold,
+	}
+	require.NoError(cfg.Validate())
+	em := newControlTestEmitter(t, cfg, newTestValidators(3))
+	now := time.Now()
+	em.prevEmittedAtTime = now.Add(-time.Second)
+
+	decide := func(power uint64) string {
+		selfParent := newControlTestEvent(1, now.Add(-time.Second), cfg.EmergencyThreshold+1).Build()
+		e := newControlTestEvent(1, now, power)
+		return em.decide(e, true, piecefunc.DecimalUnit, &selfParent.Event).Reason
+	}
+
+	for i := uint32(1); i < cfg.LowPowerBreaker.Skips; i++ {
+		require.Equal(ReasonLowPower, decide(cfg.EmergencyThreshold-1))
+		require.False(em.lowPowerBreaker.open)
+	}
+	// a skip by another reason resets the count
+	require.Equal(ReasonAllowed, decide(cfg.LimitedTpsThreshold))
+	for i := uint32(1); i <= cfg.LowPowerBreaker.Skips; i++ {
+		require.Equal(ReasonLowPower, decide(cfg.EmergencyThreshold-1))
+	}
+	require.True(em.lowPowerBreaker.open)
+
+	// the heuristics are skipped until the power recovers above the reset threshold
+	require.Equal(ReasonLowPowerBreaker, decide(cfg.EmergencyThreshold+1))
+	require.Equal(ReasonLowPowerBreaker, decide(cfg.LowPowerBreaker.ResetThreshold))
+	require.Equal(ReasonAllowed, decide(cfg.LimitedTpsThreshold))
+	require.False(em.lowPowerBreaker.open)
+}
+
+// fakeTxSource is a TxSource driven by the test
+type fakeTxSource struct {
+	pending bool
+}
+
+func (s *fakeTxSource) HasPending() bool {
+	return s.pending
+}
+
+func TestTxSource(t *testing.T) {
+	require := require.New(t)
+
+	src := &fakeTxSource{}
+	cfg := DefaultConfig()
+	cfg.TxSource = src
+	em := newControlTestEmitter(t, cfg, newTestValidators(3))
+	now := time.Now()
+	em.prevEmittedAtTime = now.Add(-time.Second)
+	em.prevIdleTime = em.prevEmittedAtTime
+	em.stakeRatio[1] = piecefunc.DecimalUnit
+
+	decide := func() string {
+		e := newControlTestEvent(1, now, cfg.LimitedTpsThreshold)
+		_, reason := em.decideEmit(e, false, piecefunc.DecimalUnit, nil)
+		return reason
+	}
+
+	require.Equal(ReasonIdleNoTxs, decide())
+	src.pending = true
+	require.Equal(ReasonAllowed, decide())
+	src.pending = false
+	require.Equal(ReasonIdleNoTxs, decide())
+
+	// the originated txs are respected regardless of the source
+	em.originatedTxs.Inc(common.Address{1})
+	require.Equal(ReasonAllowed, decide())
+}
+
+func TestPendingInternalTxs(t *testing.T) {
+	require := require.New(t)
+
+	cfg := DefaultConfig()
+	pending := false
+	cfg.PendingInternalTxs = func() bool {
+		return pending
+	}
+	em := newControlTestEmitter(t, cfg, newTestValidators(3))
+	now := time.Now()
+	em.prevEmittedAtTime = now.Add(-time.Second)
+	em.prevIdleTime = em.prevEmittedAtTime
+	em.stakeRatio[1] = piecefunc.DecimalUnit
+	e := newControlTestEvent(1, now, cfg.LimitedTpsThreshold)
+
+	allowed, reason := em.decideEmit(e, false, piecefunc.DecimalUnit, nil)
+	require.False(allowed)
+	require.Equal(ReasonIdleNoTxs, reason)
+
+	pending = true
+	allowed, reason = em.decideEmit(e, false, piecefunc.DecimalUnit, nil)
+	require.True(allowed)
+	require.Equal(ReasonAllowed, reason)
+}
+
+func TestMetricWindow(t *testing.T) {
+	require := require.New(t)
+
+	validators := newTestValidators(3)
+	calm := updMetric(10, 10, 11, 0, validators)
+	spike := updMetric(10, 10, 20, 0, validators)
+	require.Greater(spike, calm)
+
+	response := func(window uint32) ancestor.Metric {
+		cfg := DefaultConfig()
+		cfg.MetricWindow = window
+		em := newControlTestEmitter(t, cfg, validators)
+		for i := 0; i < 10; i++ {
+			em.smoothMetric(calm)
+		}
+		return em.smoothMetric(spike)
+	}
+
+	// no smoothing by default
+	require.Equal(spike, response(0))
+	require.Equal(spike, response(1))
+	// a larger window smooths the spike more
+	require.Equal((3*calm+spike)/4, response(4))
+	require.Less(response(8), response(4))
+	require.Greater(response(8), calm)
+}
+
+func TestPowerSlowdownMetrics(t *testing.T) {
+	require := require.New(t)
+
+	// the metrics are registered as nil ones if metrics are disabled
+	gauge, counter := &metrics.StandardGauge{}, metrics.NewCounterForced()
+	prevGauge, prevCounter := adjustedIntervalGauge, powerSlowdownCounter
+	adjustedIntervalGauge, powerSlowdownCounter = gauge, counter
+	defer func() {
+		adjustedIntervalGauge, powerSlowdownCounter = prevGauge, prevCounter
+	}()
+
+	cfg := DefaultConfig()
+	em := newControlTestEmitter(t, cfg, newTestValidators(3))
+	now := time.Now()
+	em.prevEmittedAtTime = now.Add(-time.Minute)
+
+	// half of the slow-down threshold, so the interval is in the middle between min and max
+	threshold := (cfg.NoTxsThreshold + cfg.EmergencyThreshold) / 2
+	e := newControlTestEvent(1, now, threshold/2)
+	require.False(em.isAllowedToEmit(e, true, piecefunc.DecimalUnit, nil))
+	require.Equal(ReasonPowerSlowdown, em.LastSkipReason(1))
+
+	expected := cfg.EmitIntervals.Max - (cfg.EmitIntervals.Max-cfg.EmitIntervals.Min)/2
+	require.InDelta(expected.Milliseconds(), gauge.Value(), 1)
+	require.Equal(int64(1), counter.Count())
+
+	// the gauge is updated, but the skips aren't counted if the adjusted interval has passed
+	em.prevEmittedAtTime = now.Add(-cfg.EmitIntervals.Max + time.Second)
+	e = newControlTestEvent(1, now, threshold)
+	require.True(em.isAllowedToEmit(e, true, piecefunc.DecimalUnit, nil))
+	require.Equal(cfg.EmitIntervals.Min.Milliseconds(), gauge.Value())
+	require.Equal(int64(1), counter.Count())
+}
+
+func TestDecisionRules(t *testing.T) {
+	cfg := DefaultConfig()
+	now := time.Now()
+	full := ancestor.Metric(piecefunc.DecimalUnit)
+	type result struct {
+		decided, allow bool
+		reason         string
+	}
+	r := func(decided, allow bool, reason string) result {
+		return result{decided, allow, reason}
+	}
+	undecided := result{}
+
+	t.Run("validatorsGuard", func(t *testing.T) {
+		em := newControlTestEmitter(t, cfg, newTestValidators(3))
+		require.Equal(t, undecided, r(em.validatorsGuard()))
+		em.validators = pos.NewBuilder().Build()
+		require.Equal(t, result{true, false, ReasonNoValidators}, r(em.validatorsGuard()))
+	})
+	t.Run("powerGuard", func(t *testing.T) {
+		em := newControlTestEmitter(t, cfg, newTestValidators(3))
+		selfParent := newControlTestEvent(1, now, cfg.EmergencyThreshold+1).Build()
+		low := newControlTestEvent(1, now, cfg.EmergencyThreshold-1)
+		require.Equal(t, result{true, false, ReasonLowPower}, r(em.powerGuard(low, &selfParent.Event)))
+		require.Equal(t, undecided, r(em.powerGuard(low, nil)))
+		require.Equal(t, undecided, r(em.powerGuard(newControlTestEvent(1, now, cfg.EmergencyThreshold+1), &selfParent.Event)))
+	})
+	t.Run("forceRule", func(t *testing.T) {
+		em := newControlTestEmitter(t, cfg, newTestValidators(3))
+		require.Equal(t, undecided, r(em.forceRule()))
+		em.forceEmit = true
+		require.Equal(t, result{true, true, ReasonForced}, r(em.forceRule()))
+	})
+	t.Run("devModeRule", func(t *testing.T) {
+		em := newControlTestEmitter(t, cfg, newTestValidators(1))
+		require.Equal(t, undecided, r(em.devModeRule(true, time.Second)))
+		em.config.DevMode = true
+		require.Equal(t, result{true, true, ReasonDevMode}, r(em.devModeRule(true, cfg.EmitIntervals.Min)))
+		em.validators = newTestValidators(3)
+		require.Equal(t, undecided, r(em.devModeRule(true, cfg.EmitIntervals.Min)))
+		em.validators = newTestValidators(1)
+		require.Equal(t, undecided, r(em.devModeRule(false, time.Second)))
+		require.Equal(t, undecided, r(em.devModeRule(true, cfg.EmitIntervals.Min-1)))
+	})
+	t.Run("maxPassedRule", func(t *testing.T) {
+		em := newControlTestEmitter(t, cfg, newTestValidators(3))
+		softMaxBlocks, maxBlocks := maxPassedBlocks(opera.FakeNetRules().Economy.BlockMissedSlack)
+		require.Equal(t, result{true, true, ReasonMaxTime}, r(em.maxPassedRule(full, cfg.EmitIntervals.Max, 0)))
+		require.Equal(t, result{true, true, ReasonMaxBlocks}, r(em.maxPassedRule(full, time.Second, softMaxBlocks)))
+		require.Equal(t, undecided, r(em.maxPassedRule(full/4, time.Second, softMaxBlocks)))
+		require.Equal(t, result{true, true, ReasonMaxBlocks}, r(em.maxPassedRule(full/4, time.Second, maxBlocks)))
+		require.Equal(t, undecided, r(em.maxPassedRule(full, time.Second, 0)))
+	})
+	t.Run("powerSlowdownRule", func(t *testing.T) {
+		em := newControlTestEmitter(t, cfg, newTestValidators(3))
+		threshold := (cfg.NoTxsThreshold + cfg.EmergencyThreshold) / 2
+		require.Equal(t, result{true, false, ReasonPowerSlowdown}, r(em.powerSlowdownRule(newControlTestEvent(1, now, threshold/2), time.Minute)))
+		require.Equal(t, undecided, r(em.powerSlowdownRule(newControlTestEvent(1, now, threshold/2), cfg.EmitIntervals.Max)))
+		require.Equal(t, undecided, r(em.powerSlowdownRule(newControlTestEvent(1, now, threshold+1), time.Second)))
+	})
+	t.Run("idleSlowdownRule", func(t *testing.T) {
+		em := newControlTestEmitter(t, cfg, newTestValidators(3))
+		require.Equal(t, result{true, false, ReasonIdleNoTxs}, r(em.idleSlowdownRule(false, time.Second)))
+		require.Equal(t, undecided, r(em.idleSlowdownRule(true, time.Second)))
+		require.Equal(t, undecided, r(em.idleSlowdownRule(false, cfg.EmitIntervals.Max)))
+		em.originatedTxs.Inc(common.Address{1})
+		require.Equal(t, undecided, r(em.idleSlowdownRule(false, time.Second)))
+	})
+	t.Run("efficiencyRule", func(t *testing.T) {
+		em := newControlTestEmitter(t, cfg, newTestValidators(3))
+		require.Equal(t, result{true, false, ReasonMinInterval}, r(em.efficiencyRule(true, full, cfg.EmitIntervals.Min-1, 0)))
+		require.Equal(t, undecided, r(em.efficiencyRule(true, full/2, 2*cfg.EmitIntervals.Min-1, 0)))
+		em.originatedTxs.Inc(common.Address{1})
+		require.Equal(t, result{true, false, ReasonMetricInterval}, r(em.efficiencyRule(true, full/2, 2*cfg.EmitIntervals.Min-1, 0)))
+		require.Equal(t, result{true, false, ReasonConfirmingInterval}, r(em.efficiencyRule(false, full, time.Second, cfg.EmitIntervals.Confirming-1)))
+		require.Equal(t, undecided, r(em.efficiencyRule(true, full, time.Second, 0)))
+		require.Equal(t, undecided, r(em.efficiencyRule(false, full, time.Second, cfg.EmitIntervals.Confirming)))
+		em.priority = true
+		require.Equal(t, undecided, r(em.efficiencyRule(false, full, time.Second, 0)))
+	})
+}
+
+func TestForceEmitNext(t *testing.T) {
+	require := require.New(t)
+
+	cfg := DefaultConfig()
+	em := newControlTestEmitter(t, cfg, newTestValidators(3))
+	now := time.Now()
+	em.prevEmittedAtTime = now.Add(-10 * time.Millisecond)
+
+	decide := func(power uint64) EmitDecision {
+		selfParent := newControlTestEvent(1, em.prevEmittedAtTime, cfg.EmergencyThreshold+1).Build()
+		e := newControlTestEvent(1, now, power)
+		return em.decide(e, false, piecefunc.DecimalUnit, &selfParent.Event)
+	}
+
+	require.Equal(ReasonIdleNoTxs, decide(cfg.LimitedTpsThreshold).Reason)
+
+	em.ForceEmitNext()
+	d := decide(cfg.LimitedTpsThreshold)
+	require.True(d.Allowed)
+	require.Equal(ReasonForced, d.Reason)
+	// the force is consumed by a single decision
+	require.False(em.forceEmit)
+	require.Equal(ReasonIdleNoTxs, decide(cfg.LimitedTpsThreshold).Reason)
+
+	// the power guard still applies
+	em.ForceEmitNext()
+	d = decide(cfg.EmergencyThreshold - 1)
+	require.False(d.Allowed)
+	require.Equal(ReasonLowPower, d.Reason)
+	// but the force isn't consumed by the declined decision
+	require.True(em.forceEmit)
+	d = decide(cfg.LimitedTpsThreshold)
+	require.True(d.Allowed)
+	require.Equal(ReasonForced, d.Reason)
+	require.False(em.forceEmit)
+}
+
+func TestForceEmitNextConcurrentTick(t *testing.T) {
+	em := newControlTestEmitter(t, DefaultConfig(), newTestValidators(3))
+	external := em.world.External.(*mock.MockExternal)
+	external.EXPECT().PeersNum().
+		Return(3).
+		AnyTimes()
+	external.EXPECT().IsSynced().
+		Return(true).
+		AnyTimes()
+	external.EXPECT().IsBusy().
+		Return(false).
+		AnyTimes()
+	em.world.External = &lockedExternal{External: external}
+	em.busyRate = rate.NewGauge()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			em.ForceEmitNext()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		em.tick()
+	}
+	<-done
+}
@@ -0,0 +1,146 @@
+package emitter
+
+import (
+	"time"
+
+	"github.com/Fantom-foundation/lachesis-base/emitter/ancestor"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/ethereum/go-ethereum/metrics"
+
+	"github.com/Fantom-foundation/go-opera/inter"
+)
+
+// Reasons of emission decisions, reported in EmitDecision.Reason and by LastSkipReason.
+// The values are stable, so they may be used by dashboards.
+const (
+	// ReasonAllowed is the reason of a regular emission
+	ReasonAllowed = "allowed"
+	// ReasonLowPower is the reason of a skip when the gas power is below the emergency threshold and decreasing
+	ReasonLowPower = "low_power"
+	// ReasonMaxTime is the reason of an emission forced by the max emit interval
+	ReasonMaxTime = "max_time"
+	// ReasonMaxBlocks is the reason of an emission forced by the number of blocks since the previous event
+	ReasonMaxBlocks = "max_blocks"
+	// ReasonPowerSlowdown is the reason of a skip when the emission is slowed down by a low gas power
+	ReasonPowerSlowdown = "power_slowdown"
+	// ReasonIdleNoTxs is the reason of a skip when there are no txs to confirm or originate
+	ReasonIdleNoTxs = "idle_no_txs"
+	// ReasonMinInterval is the reason of a skip when the min emit interval hasn't passed
+	ReasonMinInterval = "min_interval"
+	// ReasonMetricInterval is the reason of a skip when the min emit interval adjusted by the event metric hasn't passed
+	ReasonMetricInterval = "metric_interval"
+	// ReasonConfirmingInterval is the reason of a skip when the confirming interval hasn't passed
+	ReasonConfirmingInterval = "confirming_interval"
+	// ReasonDevMode is the reason of an emission of txs in dev mode
+	ReasonDevMode = "dev_mode"
+	// ReasonNoValidators is the reason of a skip when the validators set is empty
+	ReasonNoValidators = "no_validators"
+	// ReasonLowPowerBreaker is the reason of a skip when the low power circuit breaker is open
+	ReasonLowPowerBreaker = "low_power_breaker"
+	// ReasonForced is the reason of an emission requested by ForceEmitNext
+	ReasonForced = "forced"
+)
+
+// emitTracePeriod is a minimum period between logged emission decisions
+const emitTracePeriod = time.Second
+
+// EmitDecision is a context of a single emission decision made by the emitter.
+type EmitDecision struct {
+	Creator        idx.ValidatorID
+	Metric         ancestor.Metric
+	PassedTime     time.Duration
+	PassedTimeIdle time.Duration
+	GasPowerLeft   inter.GasPowerLeft
+	Reason         string
+	Allowed        bool
+}
+
+// SetEmitDecisionHook sets a callback which is called on every emission decision.
+// The callback is called outside of the emitter and world locks, so it may call back into the emitter.
+// Nil hook disables the notifications.
+func (em *Emitter) SetEmitDecisionHook(hook func(EmitDecision)) {
+	em.decisions.mu.Lock()
+	defer em.decisions.mu.Unlock()
+	em.decisions.hook = hook
+	em.decisions.pending = nil
+}
+
+func (em *Emitter) onEmitDecision(d EmitDecision) {
+	if em.config.TraceEmit {
+		em.emitTrace.Info(emitTracePeriod, "Emission decision",
+			"creator", d.Creator, "allowed", d.Allowed, "reason", d.Reason,
+			"metric", d.Metric, "passed", d.PassedTime, "passedIdle", d.PassedTimeIdle,
+			"power", d.GasPowerLeft.Min())
+	}
+
+	if !d.Allowed {
+		em.setLastSkipReason(d.Creator, d.Reason)
+	}
+	em.updateGasPowerGauge(d.Creator, d.GasPowerLeft.Min())
+
+	em.decisions.mu.Lock()
+	defer em.decisions.mu.Unlock()
+	if em.decisions.hook != nil {
+		em.decisions.pending = append(em.decisions.pending, d)
+	}
+}
+
+// notifyEmitDecisions passes the pending decisions to the hook.
+// It must be called without holding the world lock.
+func (em *Emitter) notifyEmitDecisions() {
+	em.decisions.mu.Lock()
+	hook, pending := em.decisions.hook, em.decisions.pending
+	em.decisions.pending = nil
+	em.decisions.mu.Unlock()
+
+	for _, d := range pending {
+		hook(d)
+	}
+}
+
+func (em *Emitter) setLastSkipReason(id idx.ValidatorID, reason string) {
+	em.skipReasons.mu.Lock()
+	defer em.skipReasons.mu.Unlock()
+	if em.skipReasons.last == nil {
+		em.skipReasons.last = make(map[idx.ValidatorID]string)
+	}
+	em.skipReasons.last[id] = reason
+}
+
+// resetLastSkipReason is called after a successful emission
+func (em *Emitter) resetLastSkipReason(id idx.ValidatorID) {
+	em.skipReasons.mu.Lock()
+	defer em.skipReasons.mu.Unlock()
+	delete(em.skipReasons.last, id)
+}
+
+// LastSkipReason returns the reason of the last skipped emission of the validator,
+// or empty string if the validator emitted an event after it.
+// It's safe for concurrent use.
+func (em *Emitter) LastSkipReason(id idx.ValidatorID) string {
+	em.skipReasons.mu.RLock()
+	defer em.skipReasons.mu.RUnlock()
+	return em.skipReasons.last[id]
+}
+
+var adjustedIntervalGauge = metrics.GetOrRegisterGauge("opera/emitter/adjusted_interval", nil)
+var powerSlowdownCounter = metrics.GetOrRegisterCounter("opera/emitter/power_slowdown_skips", nil)
+
+// reportPowerSlowdown reports the emit interval (in milliseconds) adjusted by the low power slow-down,
+// and counts the emissions skipped by it
+func reportPowerSlowdown(adjustedInterval time.Duration, throttled bool) {
+	adjustedIntervalGauge.Update(adjustedInterval.Milliseconds())
+	if throttled {
+		powerSlowdownCounter.Inc(1)
+	}
+}
+
+var gasPowerGauge = metrics.GetOrRegisterGauge("opera/emitter/gas_power_min", nil)
+
+// updateGasPowerGauge reports the gas power left of the emitter's own validator
+func (em *Emitter) updateGasPowerGauge(id idx.ValidatorID, power uint64) {
+	if id != em.config.Validator.ID {
+		return
+	}
+	gasPowerGauge.Update(int64(power))
+}
@@ -0,0 +1,130 @@
+package emitter
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Fantom-foundation/lachesis-base/emitter/ancestor"
+	"github.com/Fantom-foundation/lachesis-base/utils/piecefunc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Fantom-foundation/go-opera/inter"
+	"github.com/Fantom-foundation/go-opera/opera"
+	"github.com/Fantom-foundation/go-opera/utils"
+)
+
+// decisionScenario is an emitter state along with the expected emission decision
+type decisionScenario struct {
+	Name string `json:"name"`
+	// Power is the gas power left of the event
+	Power uint64 `json:"power"`
+	// SelfParentPower is the gas power left of the self-parent, no self-parent if omitted
+	SelfParentPower *uint64 `json:"selfParentPower"`
+	// Metric is the event metric in range [0, 1], 1 if omitted
+	Metric *float64 `json:"metric"`
+	// Passed is the time since the previous emission
+	Passed utils.JSONDuration `json:"passed"`
+	// PassedIdle is the time since the emitter was idle, same as Passed if omitted
+	PassedIdle *utils.JSONDuration `json:"passedIdle"`
+	// Idle is true if there are no originated txs to confirm
+	Idle bool `json:"idle"`
+	// Txs is true if the event originates txs
+	Txs bool `json:"txs"`
+	// StakeRatio is the ratio of stake before the validator, 1 if omitted
+	StakeRatio *float64 `json:"stakeRatio"`
+	Expected   struct {
+		Allowed bool   `json:"allowed"`
+		Reason  string `json:"reason"`
+	} `json:"expected"`
+}
+
+func (s decisionScenario) decide(t *testing.T) EmitDecision {
+	cfg := DefaultConfig()
+	em := newControlTestEmitter(t, cfg, newTestValidators(3))
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	em.clock = clock
+
+	em.prevEmittedAtTime = clock.Now().Add(-time.Duration(s.Passed))
+	em.prevIdleTime = em.prevEmittedAtTime
+	if s.PassedIdle != nil {
+		em.prevIdleTime = clock.Now().Add(-time.Duration(*s.PassedIdle))
+	}
+	if !s.Idle {
+		em.originatedTxs.Inc(common.Address{1})
+	}
+	stakeRatio := 1.0
+	if s.StakeRatio != nil {
+		stakeRatio = *s.StakeRatio
+	}
+	em.stakeRatio[1] = uint64(stakeRatio * piecefunc.DecimalUnit)
+	metric := 1.0
+	if s.Metric != nil {
+		metric = *s.Metric
+	}
+
+	var selfParent *inter.Event
+	if s.SelfParentPower != nil {
+		selfParent = &newControlTestEvent(1, em.prevEmittedAtTime, *s.SelfParentPower).Build().Event
+	}
+	e := newControlTestEvent(1, clock.Now(), s.Power)
+	return em.decide(e, s.Txs, ancestor.Metric(metric*piecefunc.DecimalUnit), selfParent)
+}
+
+// TestDecisionScenarios runs the emission decision on the golden scenarios of testdata/decisions.json
+func TestDecisionScenarios(t *testing.T) {
+	data, err := os.ReadFile("testdata/decisions.json")
+	require.NoError(t, err)
+	var scenarios []decisionScenario
+	require.NoError(t, json.Unmarshal(data, &scenarios))
+	require.NotEmpty(t, scenarios)
+
+	for _, s := range scenarios {
+		s := s
+		t.Run(s.Name, func(t *testing.T) {
+			d := s.decide(t)
+			require.Equal(t, s.Expected.Reason, d.Reason)
+			require.Equal(t, s.Expected.Allowed, d.Allowed)
+		})
+	}
+}
+
+// TestReasonValues checks the exported reason values, which are used by dashboards
+func TestReasonValues(t *testing.T) {
+	for reason, value := range map[string]string{
+		ReasonAllowed:            "allowed",
+		ReasonLowPower:           "low_power",
+		ReasonMaxTime:            "max_time",
+		ReasonMaxBlocks:          "max_blocks",
+		ReasonPowerSlowdown:      "power_slowdown",
+		ReasonIdleNoTxs:          "idle_no_txs",
+		ReasonMinInterval:        "min_interval",
+		ReasonMetricInterval:     "metric_interval",
+		ReasonConfirmingInterval: "confirming_interval",
+		ReasonDevMode:            "dev_mode",
+		ReasonNoValidators:       "no_validators",
+		ReasonLowPowerBreaker:    "low_power_breaker",
+		ReasonForced:             "forced",
+	} {
+		require.Equal(t, value, reason)
+	}
+}
+
+func TestMaxBlocksReason(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Validator.ID = 1
+	start := time.Unix(1600000000, 0)
+	sim, err := NewSimulation(cfg, newTestValidators(3), opera.FakeNetRules(), start)
+	require.NoError(t, err)
+
+	_, maxBlocks := maxPassedBlocks(opera.FakeNetRules().Economy.BlockMissedSlack)
+	sim.SetLatestBlock(maxBlocks)
+	d := sim.ReplayDecisions([]RecordedObservation{{
+		Event:  newControlTestEvent(1, start.Add(time.Second), cfg.LimitedTpsThreshold),
+		Metric: piecefunc.DecimalUnit,
+	}})[0]
+	require.True(t, d.Allowed)
+	require.Equal(t, ReasonMaxBlocks, d.Reason)
+}
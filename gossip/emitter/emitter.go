@@ -39,6 +39,8 @@ type Emitter struct {
 
 	syncStatus syncStatus
 
+	clock Clock
+
 	prevIdleTime       time.Time
 	prevEmittedAtTime  time.Time
 	prevEmittedAtBlock idx.Block
@@ -66,6 +68,14 @@ type Emitter struct {
 
 	intervals                EmitIntervals
 	globalConfirmingInterval time.Duration
+	// priority is a one-shot flag which skips the confirming slow-down in the next emission decision
+	priority bool
+	// forceEmit is a one-shot flag which allows the next emission regardless of the heuristics, except the power guard
+	forceEmit bool
+	// peerRateBackoff widens the minimum emit interval while peers emit events rapidly
+	peerRateBackoff time.Duration
+	// peerRateSampledAt is the time of the previous peers event rate sample
+	peerRateSampledAt time.Time
 
 	done chan struct{}
 	wg   sync.WaitGroup
@@ -84,14 +94,55 @@ type Emitter struct {
 	emittedEvFile    *os.File
 	busyRate         *rate.Gauge
 
+	decisions struct {
+		mu      sync.Mutex
+		hook    func(EmitDecision)
+		pending []EmitDecision
+	}
+
+	// metricWindow is the ring of the recent quorum metrics, which are smoothed by smoothMetric
+	metricWindow struct {
+		samples []ancestor.Metric
+		next    int
+	}
+
+	// lowPowerBreaker is the state of the low power circuit breaker
+	lowPowerBreaker struct {
+		skips uint32
+		open  bool
+	}
+
+	// catchUp is the conservative emission window after start
+	catchUp struct {
+		until time.Time
+		block idx.Block
+	}
+
+	// epochEmits is the emission statistics of the current epoch
+	epochEmits struct {
+		start time.Time
+		count uint64
+	}
+
+	skipReasons struct {
+		mu   sync.RWMutex
+		last map[idx.ValidatorID]string
+	}
+
+	emitTrace logger.Periodic
+
 	logger.Periodic
 }
 
 // NewEmitter creation.
+// It panics if the config is invalid, see Config.Validate.
 func NewEmitter(
 	config Config,
 	world World,
 ) *Emitter {
+	if err := config.Validate(); err != nil {
+		panic(fmt.Errorf("invalid emitter config: %w", err))
+	}
 	// Randomize event time to decrease chance of 2 parallel instances emitting event at the same time
 	// It increases the chance of detecting parallel instances
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -100,9 +151,11 @@ func NewEmitter(
 	return &Emitter{
 		config:                   config,
 		world:                    world,
+		clock:                    realClock{},
 		originatedTxs:            originatedtxs.New(SenderCountBufferSize),
 		intervals:                config.EmitIntervals,
 		globalConfirmingInterval: config.EmitIntervals.Confirming,
+		emitTrace:                logger.Periodic{Instance: logger.New()},
 		Periodic:                 logger.Periodic{Instance: logger.New()},
 	}
 }
@@ -112,8 +165,12 @@ func (em *Emitter) init() {
 	em.syncStatus.startup = time.Now()
 	em.syncStatus.lastConnected = time.Now()
 	em.syncStatus.p2pSynced = time.Now()
+	// events may be already processed, so the epoch is read and applied under the world lock
+	em.world.Lock()
 	validators, epoch := em.world.GetEpochValidators()
 	em.OnNewEpoch(validators, epoch)
+	em.world.Unlock()
+	em.startCatchUp()
 
 	if len(em.config.PrevEmittedEventFile.Path) != 0 {
 		em.emittedEventFile = openPrevActionFile(em.config.PrevEmittedEventFile.Path, em.config.PrevEmittedEventFile.SyncMode)
@@ -192,9 +249,14 @@ func (em *Emitter) tick() {
 		return
 	}
 
+	em.samplePeerEventRate()
 	em.recheckChallenges()
 	em.recheckIdleTime()
-	if time.Since(em.prevEmittedAtTime) >= em.intervals.Min {
+	// the force is set and consumed under the world lock
+	em.world.Lock()
+	forced := em.forceEmit
+	em.world.Unlock()
+	if forced || em.clock.Now().Sub(em.prevEmittedAtTime) >= em.intervals.Min {
 		_, _ = em.EmitEvent()
 	}
 }
@@ -238,6 +300,8 @@ func (em *Emitter) EmitEvent() (*inter.EventPayload, error) {
 	if em.world.IsBusy() {
 		return nil, nil
 	}
+	// decisions are passed to the hook after the world is unlocked
+	defer em.notifyEmitDecisions()
 	em.world.Lock()
 	defer em.world.Unlock()
 
@@ -263,8 +327,7 @@ func (em *Emitter) EmitEvent() (*inter.EventPayload, error) {
 	// broadcast the event
 	em.world.Broadcast(e)
 
-	em.prevEmittedAtTime = time.Now() // record time after connecting, to add the event processing time"
-	em.prevEmittedAtBlock = em.world.GetLatestBlockIndex()
+	em.onEmitted(e.Creator()) // record time after connecting, to add the event processing time"
 
 	// metrics
 	if tracing.Enabled() {
@@ -278,8 +341,8 @@ func (em *Emitter) EmitEvent() (*inter.EventPayload, error) {
 	return e, nil
 }
 
-func (em *Emitter) loadPrevEmitTime() time.Time {
-	prevEventID := em.world.GetLastEvent(em.epoch, em.config.Validator.ID)
+func (em *Emitter) loadPrevEmitTime(epoch idx.Epoch) time.Time {
+	prevEventID := em.world.GetLastEvent(epoch, em.config.Validator.ID)
 	if prevEventID == nil {
 		return em.prevEmittedAtTime
 	}
@@ -358,7 +421,7 @@ func (em *Emitter) createEvent(sortedTxs *types.TransactionsByPriceAndNonce) (*i
 
 	mutEvent.SetParents(parents)
 	mutEvent.SetLamport(maxLamport + 1)
-	mutEvent.SetCreationTime(inter.MaxTimestamp(inter.Timestamp(time.Now().UnixNano()), selfParentTime+1))
+	mutEvent.SetCreationTime(inter.MaxTimestamp(inter.Timestamp(em.clock.Now().UnixNano()), selfParentTime+1))
 
 	// add LLR votes
 	//em.addLlrEpochVote(mutEvent)
@@ -386,9 +449,11 @@ func (em *Emitter) createEvent(sortedTxs *types.TransactionsByPriceAndNonce) (*i
 				metric = 0.03 * piecefunc.DecimalUnit
 			}
 			metric = overheadAdjustedEventMetricF(em.validators.Len(), uint64(em.busyRate.Rate1()*piecefunc.DecimalUnit), metric)
-			metric = kickStartMetric(metric, mutEvent.Seq())
+			if !em.config.DisableKickstart {
+				metric = kickStartMetric(metric, mutEvent.Seq())
+			}
 		} else if em.quorumIndexer != nil {
-			metric = eventMetric(em.quorumIndexer.GetMetricOf(hash.Events{mutEvent.ID()}), mutEvent.Seq())
+			metric = eventMetric(em.smoothMetric(em.quorumIndexer.GetMetricOf(hash.Events{mutEvent.ID()})), mutEvent.Seq(), !em.config.DisableKickstart)
 			metric = overheadAdjustedEventMetricF(em.validators.Len(), uint64(em.busyRate.Rate1()*piecefunc.DecimalUnit), metric)
 		}
 	})
@@ -447,7 +512,21 @@ func (em *Emitter) createEvent(sortedTxs *types.TransactionsByPriceAndNonce) (*i
 	return event, nil
 }
 
+// Intervals returns a copy of the current emit intervals.
+// It locks the world, so it must not be called from the world's callbacks.
+func (em *Emitter) Intervals() EmitIntervals {
+	em.world.Lock()
+	defer em.world.Unlock()
+	return em.intervals
+}
+
 func (em *Emitter) idle() bool {
+	if em.config.TxSource != nil && em.config.TxSource.HasPending() {
+		return false
+	}
+	if em.config.PendingInternalTxs != nil && em.config.PendingInternalTxs() {
+		return false
+	}
 	return em.originatedTxs.Empty()
 }
 
@@ -116,3 +116,21 @@ func TestEmitter(t *testing.T) {
 		em.tick()
 	})
 }
+
+func TestIntervals(t *testing.T) {
+	require := require.New(t)
+
+	em := newControlTestEmitter(t, DefaultConfig(), newTestValidators(3))
+	em.intervals = EmitIntervals{
+		Min:        time.Second,
+		Max:        time.Minute,
+		Confirming: 2 * time.Second,
+	}
+
+	intervals := em.Intervals()
+	require.Equal(em.intervals, intervals)
+
+	intervals.Min = time.Hour
+	require.Equal(time.Second, em.intervals.Min)
+	require.Equal(time.Second, em.Intervals().Min)
+}
@@ -19,77 +19,139 @@ import (
 var eventConnectedCounter = metrics.GetOrRegisterCounter("opera/events/connected", nil)
 var eventConfirmedCounter = metrics.GetOrRegisterCounter("opera/events/confirmed", nil)
 var epochsGauge = metrics.GetOrRegisterGauge("opera/epochs", nil)
+var epochEmittedGauge = metrics.GetOrRegisterGauge("opera/events/epoch/emitted", nil)
+var epochEmitRateGauge = metrics.GetOrRegisterGaugeFloat64("opera/events/epoch/rate", nil)
 
-// OnNewEpoch should be called after each epoch change, and on startup
+// OnNewEpoch should be called after each epoch change, and on startup.
+// The state of the new epoch is calculated first and then applied at once,
+// and the caller must hold the world lock, so emission decisions never observe a mix of epochs.
 func (em *Emitter) OnNewEpoch(newValidators *pos.Validators, newEpoch idx.Epoch) {
-	em.maxParents = em.config.MaxParents
+	maxParents := em.config.MaxParents
 	rules := em.world.GetRules()
-	if em.maxParents == 0 {
-		em.maxParents = rules.Dag.MaxParents
+	if maxParents == 0 {
+		maxParents = rules.Dag.MaxParents
 	}
-	if em.maxParents > rules.Dag.MaxParents {
-		em.maxParents = rules.Dag.MaxParents
+	if maxParents > rules.Dag.MaxParents {
+		maxParents = rules.Dag.MaxParents
 	}
 	if em.validators != nil && em.isValidator() && !em.validators.Exists(em.config.Validator.ID) && newValidators.Exists(em.config.Validator.ID) {
 		em.syncStatus.becameValidator = time.Now()
 	}
+	isValidator := em.config.Validator.ID != 0 && newValidators.Exists(em.config.Validator.ID)
 
+	var (
+		prevEmittedAtTime        = em.prevEmittedAtTime
+		intervals                = em.intervals
+		globalConfirmingInterval = em.globalConfirmingInterval
+		stakeRatio               = make(map[idx.ValidatorID]uint64)
+		expectedEmitIntervals    = make(map[idx.ValidatorID]time.Duration)
+		quorumIndexer            = em.quorumIndexer
+		fcIndexer                = em.fcIndexer
+		payloadIndexer           = em.payloadIndexer
+	)
+	if isValidator {
+		prevEmittedAtTime = em.loadPrevEmitTime(newEpoch)
+
+		// get current adjustments from emitterdriver contract
+		statedb := em.world.StateDB()
+		var (
+			extMinInterval        time.Duration
+			extConfirmingInterval time.Duration
+			switchToFCIndexer     bool
+		)
+		if statedb != nil {
+			switchToFCIndexer = statedb.GetState(emitterdriver.ContractAddress, utils.U64to256(0)) != (common.Hash{0})
+			extMinInterval = time.Duration(statedb.GetState(emitterdriver.ContractAddress, utils.U64to256(1)).Big().Uint64())
+			extConfirmingInterval = time.Duration(statedb.GetState(emitterdriver.ContractAddress, utils.U64to256(2)).Big().Uint64())
+		}
+		if extMinInterval == 0 {
+			extMinInterval = em.config.EmitIntervals.Min
+		}
+		if extConfirmingInterval == 0 {
+			extConfirmingInterval = em.config.EmitIntervals.Confirming
+		}
+
+		// sanity check to ensure that durations aren't too small/large
+		intervals.Min = maxDuration(minDuration(em.config.EmitIntervals.Min*20, extMinInterval), em.config.EmitIntervals.Min/4)
+		globalConfirmingInterval = maxDuration(minDuration(em.config.EmitIntervals.Confirming*20, extConfirmingInterval), em.config.EmitIntervals.Confirming/4)
+		// nobody is offline at the epoch start
+		stakeRatio, expectedEmitIntervals = confirmingIntervals(newValidators, nil, globalConfirmingInterval)
+		intervals.Confirming = expectedEmitIntervals[em.config.Validator.ID]
+
+		if switchToFCIndexer {
+			quorumIndexer = nil
+			fcIndexer = ancestor.NewFCIndexer(newValidators, em.world.DagIndex(), em.config.Validator.ID)
+		} else {
+			quorumIndexer = ancestor.NewQuorumIndexer(newValidators, vecmt2dagidx.Wrap(em.world.DagIndex()),
+				func(median, current, update idx.Event, validatorIdx idx.Validator) ancestor.Metric {
+					return updMetric(median, current, update, validatorIdx, newValidators)
+				})
+			fcIndexer = nil
+		}
+		quorumIndexer = ancestor.NewQuorumIndexer(newValidators, vecmt2dagidx.Wrap(em.world.DagIndex()),
+			func(median, current, update idx.Event, validatorIdx idx.Validator) ancestor.Metric {
+				return updMetric(median, current, update, validatorIdx, newValidators)
+			})
+		payloadIndexer = ancestor.NewPayloadIndexer(PayloadIndexerSize)
+	}
+
+	// apply the new epoch
+	em.maxParents = maxParents
 	em.validators, em.epoch = newValidators, newEpoch
+	em.stakeRatio, em.expectedEmitIntervals = stakeRatio, expectedEmitIntervals
+	em.intervals, em.globalConfirmingInterval = intervals, globalConfirmingInterval
+	em.offlineValidators = make(map[idx.ValidatorID]bool)
+	em.challenges = make(map[idx.ValidatorID]time.Time)
+	// metrics of the previous epoch aren't comparable with the new quorum indexer
+	em.metricWindow.samples, em.metricWindow.next = nil, 0
+	em.resetEpochEmitStats()
 
-	if !em.isValidator() {
+	if !isValidator {
 		return
 	}
-	em.prevEmittedAtTime = em.loadPrevEmitTime()
-
+	em.prevEmittedAtTime = prevEmittedAtTime
 	em.originatedTxs.Clear()
 	em.pendingGas = 0
+	em.quorumIndexer, em.fcIndexer, em.payloadIndexer = quorumIndexer, fcIndexer, payloadIndexer
 
-	em.offlineValidators = make(map[idx.ValidatorID]bool)
-	em.challenges = make(map[idx.ValidatorID]time.Time)
-	em.expectedEmitIntervals = make(map[idx.ValidatorID]time.Duration)
-	em.stakeRatio = make(map[idx.ValidatorID]uint64)
+	epochsGauge.Update(int64(newEpoch))
+}
 
-	// get current adjustments from emitterdriver contract
-	statedb := em.world.StateDB()
-	var (
-		extMinInterval        time.Duration
-		extConfirmingInterval time.Duration
-		switchToFCIndexer     bool
-	)
-	if statedb != nil {
-		switchToFCIndexer = statedb.GetState(emitterdriver.ContractAddress, utils.U64to256(0)) != (common.Hash{0})
-		extMinInterval = time.Duration(statedb.GetState(emitterdriver.ContractAddress, utils.U64to256(1)).Big().Uint64())
-		extConfirmingInterval = time.Duration(statedb.GetState(emitterdriver.ContractAddress, utils.U64to256(2)).Big().Uint64())
-	}
-	if extMinInterval == 0 {
-		extMinInterval = em.config.EmitIntervals.Min
-	}
-	if extConfirmingInterval == 0 {
-		extConfirmingInterval = em.config.EmitIntervals.Confirming
-	}
+// onEmitted is called after an event is emitted
+func (em *Emitter) onEmitted(creator idx.ValidatorID) {
+	em.prevEmittedAtTime = em.clock.Now()
+	em.prevEmittedAtBlock = em.world.GetLatestBlockIndex()
+	em.resetLastSkipReason(creator)
+	// a full emission cycle is observed, so the previous emission isn't stale anymore
+	em.catchUp.until = time.Time{}
+
+	em.epochEmits.count++
+	count, rate := em.epochEmitStats()
+	epochEmittedGauge.Update(int64(count))
+	epochEmitRateGauge.Update(rate)
+}
 
-	// sanity check to ensure that durations aren't too small/large
-	em.intervals.Min = maxDuration(minDuration(em.config.EmitIntervals.Min*20, extMinInterval), em.config.EmitIntervals.Min/4)
-	em.globalConfirmingInterval = maxDuration(minDuration(em.config.EmitIntervals.Confirming*20, extConfirmingInterval), em.config.EmitIntervals.Confirming/4)
-	em.recountConfirmingIntervals(newValidators)
+func (em *Emitter) resetEpochEmitStats() {
+	em.epochEmits.start = em.clock.Now()
+	em.epochEmits.count = 0
+	epochEmittedGauge.Update(0)
+	epochEmitRateGauge.Update(0)
+}
 
-	if switchToFCIndexer {
-		em.quorumIndexer = nil
-		em.fcIndexer = ancestor.NewFCIndexer(newValidators, em.world.DagIndex(), em.config.Validator.ID)
-	} else {
-		em.quorumIndexer = ancestor.NewQuorumIndexer(newValidators, vecmt2dagidx.Wrap(em.world.DagIndex()),
-			func(median, current, update idx.Event, validatorIdx idx.Validator) ancestor.Metric {
-				return updMetric(median, current, update, validatorIdx, newValidators)
-			})
-		em.fcIndexer = nil
+func (em *Emitter) epochEmitStats() (count uint64, ratePerSec float64) {
+	count = em.epochEmits.count
+	if passed := em.clock.Now().Sub(em.epochEmits.start); passed > 0 {
+		ratePerSec = float64(count) / passed.Seconds()
 	}
-	em.quorumIndexer = ancestor.NewQuorumIndexer(newValidators, vecmt2dagidx.Wrap(em.world.DagIndex()),
-		func(median, current, update idx.Event, validatorIdx idx.Validator) ancestor.Metric {
-			return updMetric(median, current, update, validatorIdx, newValidators)
-		})
-	em.payloadIndexer = ancestor.NewPayloadIndexer(PayloadIndexerSize)
+	return count, ratePerSec
+}
 
-	epochsGauge.Update(int64(newEpoch))
+// EpochEmitStats returns number of events emitted in the current epoch, and the average emission rate since the epoch start.
+// It locks the world, so it must not be called from the world's callbacks.
+func (em *Emitter) EpochEmitStats() (count uint64, ratePerSec float64) {
+	em.world.Lock()
+	defer em.world.Unlock()
+	return em.epochEmitStats()
 }
 
 // OnEventConnected tracks new events
@@ -0,0 +1,61 @@
+package emitter
+
+import (
+	"time"
+
+	"github.com/Fantom-foundation/lachesis-base/emitter/ancestor"
+
+	"github.com/Fantom-foundation/go-opera/inter"
+)
+
+// RecordedObservation is a recorded input of a single emission decision.
+type RecordedObservation struct {
+	// Event is the event candidate, its creation time is used as the current time
+	Event      inter.EventI
+	SelfParent *inter.Event
+	Txs        bool
+	Metric     ancestor.Metric
+}
+
+// replayClock is a clock which is set to the time of the replayed observation
+type replayClock struct {
+	now time.Time
+}
+
+func (c *replayClock) Now() time.Time {
+	return c.now
+}
+
+// ReplayDecisions drives the emission decisions with the recorded observations and returns the decisions.
+// Time is taken from the observations instead of the wall clock, so the result is deterministic.
+// If an emission is allowed, the observed event is considered as emitted.
+// The emitter's clock is restored afterwards, but the state of emitter is left as after the last observation.
+func (em *Emitter) ReplayDecisions(observations []RecordedObservation) []EmitDecision {
+	clock := &replayClock{}
+	prevClock := em.clock
+	em.clock = clock
+	defer func() {
+		em.clock = prevClock
+	}()
+
+	decisions := make([]EmitDecision, 0, len(observations))
+	for _, o := range observations {
+		clock.now = o.Event.CreationTime().Time()
+		em.recheckIdleTime()
+		decisions = append(decisions, em.replayDecision(o))
+		em.notifyEmitDecisions()
+	}
+	return decisions
+}
+
+func (em *Emitter) replayDecision(o RecordedObservation) EmitDecision {
+	em.world.Lock()
+	defer em.world.Unlock()
+
+	d := em.decide(o.Event, o.Txs, o.Metric, o.SelfParent)
+	em.onEmitDecision(d)
+	if d.Allowed {
+		em.onEmitted(d.Creator)
+	}
+	return d
+}
@@ -0,0 +1,65 @@
+package emitter
+
+import (
+	"time"
+
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/Fantom-foundation/lachesis-base/inter/pos"
+
+	"github.com/Fantom-foundation/go-opera/opera"
+)
+
+// simWorld is an external world of a simulated emitter.
+// It provides only what is used by the emission decisions, other calls panic.
+type simWorld struct {
+	External
+	rules       opera.Rules
+	latestBlock idx.Block
+}
+
+func (w *simWorld) Lock()   {}
+func (w *simWorld) Unlock() {}
+
+func (w *simWorld) GetRules() opera.Rules {
+	return w.rules
+}
+
+func (w *simWorld) GetLatestBlockIndex() idx.Block {
+	return w.latestBlock
+}
+
+// Simulation is an emitter detached from a node, which is used for offline simulation of emission decisions.
+// Decisions are made with ReplayDecisions.
+type Simulation struct {
+	*Emitter
+	world *simWorld
+}
+
+// NewSimulation makes a simulated emitter of config.Validator.ID, which is started at the given time.
+// Emit intervals aren't randomized, so the simulation is deterministic.
+// The config is validated.
+func NewSimulation(config Config, validators *pos.Validators, rules opera.Rules, start time.Time) (*Simulation, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	world := &simWorld{rules: rules}
+	em := NewEmitter(config, World{External: world})
+	em.config.EmitIntervals = config.EmitIntervals
+	em.intervals = config.EmitIntervals
+	em.validators = validators
+	em.offlineValidators = make(map[idx.ValidatorID]bool)
+	em.expectedEmitIntervals = make(map[idx.ValidatorID]time.Duration)
+	em.stakeRatio = make(map[idx.ValidatorID]uint64)
+	em.recountConfirmingIntervals(validators)
+	em.prevEmittedAtTime = start
+	em.prevIdleTime = start
+	return &Simulation{
+		Emitter: em,
+		world:   world,
+	}, nil
+}
+
+// SetLatestBlock sets the latest block index observed by the simulated emitter.
+func (s *Simulation) SetLatestBlock(n idx.Block) {
+	s.world.latestBlock = n
+}
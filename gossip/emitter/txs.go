@@ -24,6 +24,14 @@ const (
 	TxTurnNonces        = 32
 )
 
+// TxSource reports whether there are txs waiting for emission, which makes the emitter not idle,
+// in addition to the txs originated by the emitter and not confirmed yet.
+// It's called both with and without the world lock held, so it must not lock the world,
+// and it must be safe for concurrent use.
+type TxSource interface {
+	HasPending() bool
+}
+
 func max64(a, b uint64) uint64 {
 	if a > b {
 		return a
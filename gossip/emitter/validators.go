@@ -13,6 +13,14 @@ const (
 )
 
 func (em *Emitter) recountConfirmingIntervals(validators *pos.Validators) {
+	em.stakeRatio, em.expectedEmitIntervals = confirmingIntervals(validators, em.offlineValidators, em.globalConfirmingInterval)
+	em.intervals.Confirming = em.expectedEmitIntervals[em.config.Validator.ID]
+}
+
+// confirmingIntervals calculates the stake ratios and the expected emit intervals of the validators
+func confirmingIntervals(validators *pos.Validators, offlineValidators map[idx.ValidatorID]bool, globalConfirmingInterval time.Duration) (map[idx.ValidatorID]uint64, map[idx.ValidatorID]time.Duration) {
+	stakeRatios := make(map[idx.ValidatorID]uint64, validators.Len())
+	expectedEmitIntervals := make(map[idx.ValidatorID]time.Duration, validators.Len())
 	// validators with lower stake should emit fewer events to reduce network load
 	// confirmingEmitInterval = piecefunc(totalStakeBeforeMe / totalStake) * MinEmitInterval
 	totalStakeBefore := pos.Weight(0)
@@ -20,14 +28,23 @@ func (em *Emitter) recountConfirmingIntervals(validators *pos.Validators) {
 		vid := validators.GetID(idx.Validator(i))
 		// pos.Weight is uint32, so cast to uint64 to avoid an overflow
 		stakeRatio := uint64(totalStakeBefore) * uint64(piecefunc.DecimalUnit) / uint64(validators.TotalWeight())
-		if !em.offlineValidators[vid] {
+		if !offlineValidators[vid] {
 			totalStakeBefore += stake
 		}
 		confirmingEmitIntervalRatio := confirmingEmitIntervalF(stakeRatio)
-		em.stakeRatio[vid] = stakeRatio
-		em.expectedEmitIntervals[vid] = time.Duration(piecefunc.Mul(uint64(em.globalConfirmingInterval), confirmingEmitIntervalRatio))
+		stakeRatios[vid] = stakeRatio
+		expectedEmitIntervals[vid] = time.Duration(piecefunc.Mul(uint64(globalConfirmingInterval), confirmingEmitIntervalRatio))
 	}
-	em.intervals.Confirming = em.expectedEmitIntervals[em.config.Validator.ID]
+	return stakeRatios, expectedEmitIntervals
+}
+
+// StakeRatio returns ratio of stake before the validator, and whether the validator is known.
+// It locks the world, so it must not be called from the world's callbacks.
+func (em *Emitter) StakeRatio(id idx.ValidatorID) (float64, bool) {
+	em.world.Lock()
+	defer em.world.Unlock()
+	ratio, ok := em.stakeRatio[id]
+	return float64(ratio) / piecefunc.DecimalUnit, ok
 }
 
 func (em *Emitter) recheckChallenges() {
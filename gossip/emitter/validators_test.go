@@ -0,0 +1,152 @@
+package emitter
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/Fantom-foundation/lachesis-base/inter/pos"
+	"github.com/Fantom-foundation/lachesis-base/utils/piecefunc"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Fantom-foundation/go-opera/gossip/emitter/mock"
+	"github.com/Fantom-foundation/go-opera/vecmt"
+)
+
+func TestStakeRatio(t *testing.T) {
+	require := require.New(t)
+
+	em := newControlTestEmitter(t, DefaultConfig(), newTestValidators(3))
+	em.stakeRatio[2] = 0.25 * piecefunc.DecimalUnit
+
+	ratio, ok := em.StakeRatio(2)
+	require.True(ok)
+	require.Equal(0.25, ratio)
+
+	_, ok = em.StakeRatio(4)
+	require.False(ok)
+}
+
+// lockedExternal is an external world with a real lock
+type lockedExternal struct {
+	External
+	mu sync.Mutex
+	// locked is true while the lock is held, it's read only by the lock holder
+	locked bool
+}
+
+func (e *lockedExternal) Lock() {
+	e.mu.Lock()
+	e.locked = true
+}
+
+func (e *lockedExternal) Unlock() {
+	e.locked = false
+	e.mu.Unlock()
+}
+
+// newEpochTestEmitter makes an emitter of validator 1, which is able to switch epochs
+func newEpochTestEmitter(t *testing.T, cfg Config) *Emitter {
+	cfg.Validator.ID = 1
+	em := newControlTestEmitter(t, cfg, newTestValidators(3))
+	external := em.world.External.(*mock.MockExternal)
+	external.EXPECT().GetLastEvent(gomock.Any(), cfg.Validator.ID).
+		Return((*hash.Event)(nil)).
+		AnyTimes()
+	external.EXPECT().StateDB().
+		Return(nil).
+		AnyTimes()
+	external.EXPECT().DagIndex().
+		Return((*vecmt.Index)(nil)).
+		AnyTimes()
+	em.world.External = &lockedExternal{External: external}
+	return em
+}
+
+func TestOnNewEpoch(t *testing.T) {
+	require := require.New(t)
+
+	cfg := DefaultConfig()
+	em := newEpochTestEmitter(t, cfg)
+	em.OnNewEpoch(newTestValidators(3), 1)
+	em.offlineValidators[2] = true
+	em.challenges[3] = time.Now()
+	em.recountConfirmingIntervals(em.validators)
+
+	em.OnNewEpoch(newTestValidators(2), 2)
+	require.Equal(idx.Epoch(2), em.epoch)
+	require.Empty(em.offlineValidators)
+	require.Empty(em.challenges)
+	require.Equal(map[idx.ValidatorID]uint64{1: 0, 2: piecefunc.DecimalUnit / 2}, em.stakeRatio)
+	require.Len(em.expectedEmitIntervals, 2)
+	require.Equal(em.expectedEmitIntervals[1], em.intervals.Confirming)
+
+	// the state of the previous epoch isn't left if the emitter isn't a validator anymore
+	other := pos.NewBuilder()
+	other.Set(2, 1)
+	em.OnNewEpoch(other.Build(), 3)
+	require.False(em.isValidator())
+	require.Equal(map[idx.ValidatorID]uint64{}, em.stakeRatio)
+	require.Empty(em.expectedEmitIntervals)
+}
+
+func TestOnNewEpochAtomic(t *testing.T) {
+	require := require.New(t)
+
+	cfg := DefaultConfig()
+	em := newEpochTestEmitter(t, cfg)
+	epochValidators := func(epoch idx.Epoch) *pos.Validators {
+		return newTestValidators(1 + int(epoch)%5)
+	}
+	em.world.Lock()
+	em.OnNewEpoch(epochValidators(1), 1)
+	em.world.Unlock()
+
+	now := time.Now()
+	em.prevEmittedAtTime = now.Add(-time.Second)
+	e := newControlTestEvent(1, now, cfg.LimitedTpsThreshold)
+	done := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			em.world.Lock()
+			// invariant: the epoch state belongs to the current validators
+			var err error
+			if !reflect.DeepEqual(em.validators, epochValidators(em.epoch)) {
+				err = fmt.Errorf("validators of another epoch in epoch %d", em.epoch)
+			} else if len(em.stakeRatio) != int(em.validators.Len()) || len(em.expectedEmitIntervals) != int(em.validators.Len()) {
+				err = fmt.Errorf("%d stake ratios and %d intervals for %d validators", len(em.stakeRatio), len(em.expectedEmitIntervals), em.validators.Len())
+			} else if em.intervals.Confirming != em.expectedEmitIntervals[1] {
+				err = fmt.Errorf("confirming interval %s of another epoch", em.intervals.Confirming)
+			}
+			em.isAllowedToEmit(e, true, piecefunc.DecimalUnit, nil)
+			em.world.Unlock()
+			if err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	for epoch := idx.Epoch(2); epoch <= 1000; epoch++ {
+		em.world.Lock()
+		em.OnNewEpoch(epochValidators(epoch), epoch)
+		em.world.Unlock()
+	}
+	close(done)
+	require.NoError(<-errs)
+
+	ratio, ok := em.StakeRatio(1)
+	require.True(ok)
+	require.Equal(0.0, ratio)
+}
@@ -59,7 +59,7 @@ func (s *Store) GetBlock(n idx.Block) *inter.Block {
 	if n == 0 {
 		// fake genesis block for compatibility with web3
 		return &inter.Block{
-			Time:    evmcore.FakeGenesisTime - 1,
+			Time:    evmcore.DefaultFakeGenesisTime() - 1,
 			Atropos: s.fakeGenesisHash(),
 		}
 	}
@@ -4,7 +4,6 @@ import (
 	"crypto/ecdsa"
 	"github.com/ethereum/go-ethereum/log"
 	"math/big"
-	"time"
 
 	"github.com/Fantom-foundation/lachesis-base/hash"
 	"github.com/Fantom-foundation/lachesis-base/inter/idx"
@@ -17,7 +16,6 @@ import (
 
 	"github.com/Fantom-foundation/go-opera/evmcore"
 	"github.com/Fantom-foundation/go-opera/integration/makegenesis"
-	"github.com/Fantom-foundation/go-opera/inter"
 	"github.com/Fantom-foundation/go-opera/inter/drivertype"
 	"github.com/Fantom-foundation/go-opera/inter/iblockproc"
 	"github.com/Fantom-foundation/go-opera/inter/ier"
@@ -36,10 +34,6 @@ import (
 	"github.com/Fantom-foundation/go-opera/opera/genesisstore"
 )
 
-var (
-	FakeGenesisTime = inter.Timestamp(1608600000 * time.Second)
-)
-
 // FakeKey gets n-th fake private key.
 func FakeKey(n idx.ValidatorID) *ecdsa.PrivateKey {
 	return evmcore.FakeKey(uint32(n))
@@ -55,6 +49,7 @@ func FakeGenesisStoreWithRules(num idx.Validator, balance, stake *big.Int, rules
 
 func FakeGenesisStoreWithRulesAndStart(num idx.Validator, balance, stake *big.Int, rules opera.Rules, epoch idx.Epoch, block idx.Block) *genesisstore.Store {
 	builder := makegenesis.NewGenesisBuilder(memorydb.NewProducer(""))
+	genesisTime := evmcore.DefaultFakeGenesisTime()
 
 	validators := GetFakeValidators(num)
 
@@ -96,7 +91,7 @@ func FakeGenesisStoreWithRulesAndStart(num idx.Validator, balance, stake *big.In
 			BlockState: iblockproc.BlockState{
 				LastBlock: iblockproc.BlockCtx{
 					Idx:     block - 1,
-					Time:    FakeGenesisTime,
+					Time:    genesisTime,
 					Atropos: hash.Event{},
 				},
 				FinalizedStateRoot:    hash.Hash{},
@@ -110,8 +105,8 @@ func FakeGenesisStoreWithRulesAndStart(num idx.Validator, balance, stake *big.In
 			},
 			EpochState: iblockproc.EpochState{
 				Epoch:             epoch - 1,
-				EpochStart:        FakeGenesisTime,
-				PrevEpochStart:    FakeGenesisTime - 1,
+				EpochStart:        genesisTime,
+				PrevEpochStart:    genesisTime - 1,
 				EpochStateRoot:    hash.Zero,
 				Validators:        pos.NewBuilder().Build(),
 				ValidatorStates:   make([]iblockproc.ValidatorEpochState, 0),
@@ -186,7 +181,7 @@ func GetFakeValidators(num idx.Validator) gpos.Validators {
 			ID:               i,
 			Address:          addr,
 			PubKey:           publicKey,
-			CreationTime:     FakeGenesisTime,
+			CreationTime:     evmcore.DefaultFakeGenesisTime(),
 			CreationEpoch:    0,
 			DeactivatedTime:  0,
 			DeactivatedEpoch: 0,
@@ -0,0 +1,75 @@
+package makefakegenesis
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+
+	"github.com/Fantom-foundation/go-opera/evmcore"
+	"github.com/Fantom-foundation/go-opera/inter"
+	"github.com/Fantom-foundation/go-opera/opera"
+	"github.com/Fantom-foundation/go-opera/opera/contracts/driver"
+	"github.com/Fantom-foundation/go-opera/opera/contracts/driver/drivercall"
+	"github.com/Fantom-foundation/go-opera/opera/contracts/driverauth"
+	"github.com/Fantom-foundation/go-opera/opera/contracts/evmwriter"
+	"github.com/Fantom-foundation/go-opera/opera/contracts/netinit"
+	"github.com/Fantom-foundation/go-opera/opera/contracts/sfc"
+	"github.com/Fantom-foundation/go-opera/opera/contracts/sfclib"
+	"github.com/Fantom-foundation/go-opera/opera/genesis/gpos"
+)
+
+// ApplyFakeSFCGenesis deploys the network contracts into statedb and stakes the validators in SFC.
+// The SFC state is written by the same genesis txs as FakeGenesisStore executes,
+// so the storage layout is the one of the deployed SFC, but no genesis store is built.
+// Each validator is funded with and self-delegates the stake.
+// The state isn't committed.
+func ApplyFakeSFCGenesis(statedb *state.StateDB, time inter.Timestamp, validators gpos.Validators, stake *big.Int) error {
+	totalSupply := new(big.Int)
+	delegations := make([]drivercall.Delegation, 0, len(validators))
+	for _, val := range validators {
+		statedb.AddBalance(val.Address, stake)
+		totalSupply.Add(totalSupply, stake)
+		delegations = append(delegations, drivercall.Delegation{
+			Address:            val.Address,
+			ValidatorID:        val.ID,
+			Stake:              stake,
+			LockedStake:        new(big.Int),
+			EarlyUnlockPenalty: new(big.Int),
+			Rewards:            new(big.Int),
+		})
+	}
+
+	statedb.SetCode(netinit.ContractAddress, netinit.GetContractBin())
+	statedb.SetCode(driver.ContractAddress, driver.GetContractBin())
+	statedb.SetCode(driverauth.ContractAddress, driverauth.GetContractBin())
+	statedb.SetCode(sfc.ContractAddress, sfc.GetContractBin())
+	statedb.SetCode(sfclib.ContractAddress, sfclib.GetContractBin())
+	// set non-zero code for pre-compiled contracts
+	statedb.SetCode(evmwriter.ContractAddress, []byte{0})
+
+	var owner common.Address
+	if len(validators) != 0 {
+		owner = validators[0].Address
+	}
+	header := &evmcore.EvmHeader{
+		Number:   big.NewInt(0),
+		Time:     time,
+		GasLimit: math.MaxUint64,
+	}
+	rules := opera.FakeNetRules()
+	chainConfig := rules.EvmChainConfig([]opera.UpgradeHeight{{Upgrades: rules.Upgrades, Height: 0}})
+	evm := vm.NewEVM(evmcore.NewEVMBlockContext(header, nil, nil), vm.TxContext{}, statedb, chainConfig, opera.DefaultVMConfig)
+
+	// genesis txs are internal, i.e. sent by the zero address
+	for i, tx := range GetGenesisTxs(0, validators, totalSupply, delegations, owner) {
+		_, _, err := evm.Call(vm.AccountRef(common.Address{}), *tx.To(), tx.Data(), tx.Gas(), tx.Value())
+		if err != nil {
+			return fmt.Errorf("genesis tx #%d to %s failed: %v", i, tx.To().Hex(), err)
+		}
+	}
+	return nil
+}
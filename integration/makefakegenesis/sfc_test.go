@@ -0,0 +1,63 @@
+package makefakegenesis
+
+import (
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Fantom-foundation/go-opera/evmcore"
+	"github.com/Fantom-foundation/go-opera/gossip/contract/sfc100"
+	"github.com/Fantom-foundation/go-opera/opera"
+	"github.com/Fantom-foundation/go-opera/opera/contracts/sfc"
+	"github.com/Fantom-foundation/go-opera/utils"
+)
+
+func TestApplyFakeSFCGenesis(t *testing.T) {
+	require := require.New(t)
+
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	require.NoError(err)
+	validators := GetFakeValidators(3)
+	stake := utils.ToFtm(5000000)
+	genesisTime := evmcore.DefaultFakeGenesisTime()
+	require.NoError(ApplyFakeSFCGenesis(statedb, genesisTime, validators, stake))
+
+	sfcAbi, err := abi.JSON(strings.NewReader(sfc100.ContractABI))
+	require.NoError(err)
+	header := &evmcore.EvmHeader{
+		Number:   big.NewInt(1),
+		Time:     genesisTime,
+		GasLimit: math.MaxUint64,
+	}
+	rules := opera.FakeNetRules()
+	chainConfig := rules.EvmChainConfig([]opera.UpgradeHeight{{Upgrades: rules.Upgrades, Height: 0}})
+	evm := vm.NewEVM(evmcore.NewEVMBlockContext(header, nil, nil), vm.TxContext{}, statedb, chainConfig, opera.DefaultVMConfig)
+	call := func(method string, args ...interface{}) []interface{} {
+		input, err := sfcAbi.Pack(method, args...)
+		require.NoError(err)
+		ret, _, err := evm.StaticCall(vm.AccountRef(common.Address{}), sfc.ContractAddress, input, 1e10)
+		require.NoError(err)
+		res, err := sfcAbi.Unpack(method, ret)
+		require.NoError(err)
+		return res
+	}
+
+	total := new(big.Int)
+	for _, v := range validators {
+		res := call("getValidator", big.NewInt(int64(v.ID)))
+		// status, deactivatedTime, deactivatedEpoch, receivedStake, createdEpoch, createdTime, auth
+		require.Equal(stake, res[3], v.ID)
+		require.Equal(v.Address, res[6], v.ID)
+		require.Equal(stake, call("getStake", v.Address, big.NewInt(int64(v.ID)))[0], v.ID)
+		total.Add(total, stake)
+	}
+	require.Equal(total, call("totalStake")[0])
+}
@@ -0,0 +1,204 @@
+package testutil
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"time"
+
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/Fantom-foundation/lachesis-base/inter/pos"
+	"github.com/Fantom-foundation/lachesis-base/utils/piecefunc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+
+	"github.com/Fantom-foundation/go-opera/evmcore"
+	"github.com/Fantom-foundation/go-opera/gossip/emitter"
+	"github.com/Fantom-foundation/go-opera/inter"
+	"github.com/Fantom-foundation/go-opera/opera"
+	"github.com/Fantom-foundation/go-opera/utils"
+)
+
+// FakeBalance is a genesis balance of each fake validator.
+var FakeBalance = utils.ToFtm(1000000)
+
+// FakeNetwork is an in-memory network of fake validators, which starts from a fake genesis.
+// Every validator has a simulated emitter, which decides on emission of events in Step.
+// Events aren't processed by consensus, blocks are generated independently with NextBlock.
+// It isn't safe for concurrent use.
+type FakeNetwork struct {
+	Validators *pos.Validators
+	Emitters   map[idx.ValidatorID]*emitter.Simulation
+	Genesis    *evmcore.EvmBlock
+	Blocks     []*evmcore.EvmBlock
+	Events     []*inter.EventPayload
+	// Time is the current time of the network
+	Time time.Time
+
+	emitterCfg emitter.Config
+	heads      map[idx.ValidatorID]*inter.EventPayload
+	db         ethdb.Database
+}
+
+// NewFakeNetwork creates a network of validators with fake keys, each is funded with FakeBalance.
+func NewFakeNetwork(numValidators uint32) *FakeNetwork {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	if err != nil {
+		panic(err)
+	}
+	genesis, err := evmcore.TryApplyFakeGenesis(statedb, evmcore.DefaultFakeGenesisTime(), evmcore.FakeGenesisBalances(numValidators, FakeBalance))
+	if err != nil {
+		panic(err)
+	}
+
+	validators := evmcore.FakeValidators(numValidators, 1)
+	start := evmcore.DefaultFakeGenesisTime().Time()
+	emitterCfg := emitter.FakeConfig(idx.Validator(numValidators))
+	emitters := make(map[idx.ValidatorID]*emitter.Simulation, numValidators)
+	for _, id := range validators.IDs() {
+		cfg := emitterCfg
+		cfg.Validator.ID = id
+		sim, err := emitter.NewSimulation(cfg, validators, opera.FakeNetRules(), start)
+		if err != nil {
+			panic(err)
+		}
+		emitters[id] = sim
+	}
+
+	return &FakeNetwork{
+		Validators: validators,
+		Emitters:   emitters,
+		Genesis:    genesis,
+		Time:       start,
+		emitterCfg: emitterCfg,
+		heads:      make(map[idx.ValidatorID]*inter.EventPayload, numValidators),
+		db:         db,
+	}
+}
+
+// Key returns private key of the validator.
+func (n *FakeNetwork) Key(id idx.ValidatorID) *ecdsa.PrivateKey {
+	return evmcore.FakeKey(uint32(id))
+}
+
+// Address returns address of the validator.
+func (n *FakeNetwork) Address(id idx.ValidatorID) common.Address {
+	return evmcore.FakeAddress(uint32(id))
+}
+
+// Head returns the latest block.
+func (n *FakeNetwork) Head() *evmcore.EvmBlock {
+	if len(n.Blocks) == 0 {
+		return n.Genesis
+	}
+	return n.Blocks[len(n.Blocks)-1]
+}
+
+// State returns the state of the latest block.
+func (n *FakeNetwork) State() *state.StateDB {
+	statedb, err := state.New(n.Head().Root, state.NewDatabase(n.db), nil)
+	if err != nil {
+		panic(err)
+	}
+	return statedb
+}
+
+// HeadEvent returns the latest event of the validator, nil if it hasn't emitted yet.
+func (n *FakeNetwork) HeadEvent(id idx.ValidatorID) *inter.EventPayload {
+	return n.heads[id]
+}
+
+// Step advances the network time by dt and lets the emitter of every validator decide on emission of an event.
+// An emitted event is connected to its self-parent and to the latest events of other validators
+// which were emitted before this step. The txs flag tells whether the event candidates have transactions.
+// Returns the emitted events.
+func (n *FakeNetwork) Step(dt time.Duration, txs bool) []*inter.EventPayload {
+	n.Time = n.Time.Add(dt)
+	emitted := make([]*inter.EventPayload, 0, n.Validators.Len())
+	for _, id := range n.Validators.SortedIDs() {
+		sim := n.Emitters[id]
+		sim.SetLatestBlock(idx.Block(len(n.Blocks)))
+		var selfParent *inter.Event
+		if head := n.heads[id]; head != nil {
+			selfParent = &head.Event
+		}
+		e := n.nextEvent(id)
+		d := sim.ReplayDecisions([]emitter.RecordedObservation{{
+			Event:      e,
+			SelfParent: selfParent,
+			Txs:        txs,
+			Metric:     piecefunc.DecimalUnit,
+		}})[0]
+		if d.Allowed {
+			emitted = append(emitted, e.Build())
+		}
+	}
+	for _, e := range emitted {
+		n.heads[e.Creator()] = e
+	}
+	n.Events = append(n.Events, emitted...)
+	return emitted
+}
+
+// nextEvent builds the event candidate of the validator on top of the current heads
+func (n *FakeNetwork) nextEvent(id idx.ValidatorID) *inter.MutableEventPayload {
+	var (
+		parents hash.Events
+		lamport idx.Lamport
+		seq     idx.Event
+	)
+	if head := n.heads[id]; head != nil {
+		parents = append(parents, head.ID())
+		lamport = head.Lamport()
+		seq = head.Seq()
+	}
+	for _, other := range n.Validators.SortedIDs() {
+		head := n.heads[other]
+		if other == id || head == nil {
+			continue
+		}
+		parents = append(parents, head.ID())
+		if head.Lamport() > lamport {
+			lamport = head.Lamport()
+		}
+	}
+
+	power := n.emitterCfg.LimitedTpsThreshold
+	me := &inter.MutableEventPayload{}
+	me.SetEpoch(1)
+	me.SetCreator(id)
+	me.SetSeq(seq + 1)
+	me.SetLamport(lamport + 1)
+	me.SetParents(parents)
+	me.SetCreationTime(inter.Timestamp(n.Time.UnixNano()))
+	me.SetGasPowerLeft(inter.GasPowerLeft{Gas: [inter.GasPowerConfigs]uint64{power, power}})
+	return me
+}
+
+// NextBlock generates the next block, gen may add transactions into it.
+func (n *FakeNetwork) NextBlock(gen func(*evmcore.BlockGen)) *evmcore.EvmBlock {
+	blocks, _, _ := evmcore.GenerateChain(params.AllEthashProtocolChanges, n.Head(), n.db, 1, func(_ int, b *evmcore.BlockGen) {
+		if gen != nil {
+			gen(b)
+		}
+	})
+	n.Blocks = append(n.Blocks, blocks[0])
+	return blocks[0]
+}
+
+// Transfer generates the next block with a single transfer between validators.
+func (n *FakeNetwork) Transfer(from, to idx.ValidatorID, amount *big.Int) *evmcore.EvmBlock {
+	return n.NextBlock(func(b *evmcore.BlockGen) {
+		tx := types.NewTransaction(b.TxNonce(n.Address(from)), n.Address(to), amount, params.TxGas, nil, nil)
+		tx, err := types.SignTx(tx, types.HomesteadSigner{}, n.Key(from))
+		if err != nil {
+			panic(err)
+		}
+		b.AddTx(tx)
+	})
+}
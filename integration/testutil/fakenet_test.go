@@ -0,0 +1,68 @@
+package testutil
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/Fantom-foundation/lachesis-base/inter/pos"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Fantom-foundation/go-opera/gossip/emitter"
+)
+
+func TestFakeNetwork(t *testing.T) {
+	require := require.New(t)
+
+	net := NewFakeNetwork(3)
+	require.Equal(idx.Validator(3), net.Validators.Len())
+	require.Equal(pos.Weight(3), net.Validators.TotalWeight())
+	for id := idx.ValidatorID(1); id <= 3; id++ {
+		require.True(net.Validators.Exists(id))
+		require.Equal(FakeBalance, net.State().GetBalance(net.Address(id)))
+	}
+
+	amount := big.NewInt(1000)
+	net.Transfer(1, 2, amount)
+	net.Transfer(1, 3, amount)
+	net.NextBlock(nil)
+	require.Len(net.Blocks, 3)
+	require.Equal(uint64(3), net.Head().Number.Uint64())
+
+	statedb := net.State()
+	require.Equal(uint64(2), statedb.GetNonce(net.Address(1)))
+	require.Equal(new(big.Int).Sub(FakeBalance, new(big.Int).Mul(amount, big.NewInt(2))), statedb.GetBalance(net.Address(1)))
+	require.Equal(new(big.Int).Add(FakeBalance, amount), statedb.GetBalance(net.Address(2)))
+	require.Equal(new(big.Int).Add(FakeBalance, amount), statedb.GetBalance(net.Address(3)))
+}
+
+func TestFakeNetworkEmission(t *testing.T) {
+	require := require.New(t)
+
+	net := NewFakeNetwork(3)
+	// too early since the start
+	require.Empty(net.Step(time.Millisecond, true))
+
+	for step := 1; step <= 3; step++ {
+		emitted := net.Step(time.Second, true)
+		require.Len(emitted, 3)
+		for _, e := range emitted {
+			require.Equal(idx.Event(step), e.Seq())
+			require.Equal(idx.Lamport(step), e.Lamport())
+			require.Equal(net.Time, e.CreationTime().Time())
+			if step == 1 {
+				require.Empty(e.Parents())
+			} else {
+				require.Len(e.Parents(), 3)
+				require.True(e.IsSelfParent(e.Parents()[0]))
+			}
+			require.Equal(e, net.HeadEvent(e.Creator()))
+		}
+	}
+	require.Len(net.Events, 9)
+
+	// idle validators wait for the max interval
+	require.Empty(net.Step(time.Second, false))
+	require.Len(net.Step(emitter.FakeConfig(3).EmitIntervals.Max, false), 3)
+}
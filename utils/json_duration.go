@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// JSONDuration is a time.Duration which is decoded from a JSON string like "150ms"
+type JSONDuration time.Duration
+
+// UnmarshalJSON parses the duration with time.ParseDuration.
+func (d *JSONDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = JSONDuration(v)
+	return nil
+}
@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONDuration_UnmarshalJSON(t *testing.T) {
+	var d JSONDuration
+	require.NoError(t, json.Unmarshal([]byte(`"1m150ms"`), &d))
+	require.Equal(t, time.Minute+150*time.Millisecond, time.Duration(d))
+
+	require.Error(t, json.Unmarshal([]byte(`"150"`), &d))
+	require.Error(t, json.Unmarshal([]byte(`150`), &d))
+}